@@ -0,0 +1,41 @@
+package ast
+
+import (
+	"strings"
+
+	"github.com/szks-repo/gosmarty/token"
+)
+
+// ArrayLiteral は [1, 2, 3] や ["a" => 1, "b" => $x]、array(...) のような配列リテラルを表します
+type ArrayLiteral struct {
+	Token token.Token // The '[' or 'array' token
+	// Keys[i] は Values[i] のキー ('=>' がない要素は nil)
+	Keys   []Node
+	Values []Node
+}
+
+func (al *ArrayLiteral) TokenLiteral() string {
+	return al.Token.Literal
+}
+
+// HasKeys は '=>' でキーを指定した要素があれば true を返します
+func (al *ArrayLiteral) HasKeys() bool {
+	for _, key := range al.Keys {
+		if key != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (al *ArrayLiteral) String() string {
+	elements := make([]string, len(al.Values))
+	for i, val := range al.Values {
+		if al.Keys[i] != nil {
+			elements[i] = al.Keys[i].String() + " => " + val.String()
+		} else {
+			elements[i] = val.String()
+		}
+	}
+	return "[" + strings.Join(elements, ", ") + "]"
+}
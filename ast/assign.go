@@ -0,0 +1,18 @@
+package ast
+
+import "github.com/szks-repo/gosmarty/token"
+
+// AssignNode は {assign var="name" value=...} を表します
+type AssignNode struct {
+	Token token.Token // 'assign' トークン
+	Name  string      // var属性で指定された変数名
+	Value Node        // value属性で指定された式
+}
+
+func (an *AssignNode) TokenLiteral() string {
+	return an.Token.Literal
+}
+
+func (an *AssignNode) String() string {
+	return "{assign var=" + an.Name + " value=" + an.Value.String() + "}"
+}
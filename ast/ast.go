@@ -23,13 +23,18 @@ func (t *Tree) String() string {
 // ActionNode は評価されるべきアクション（例: {$name}）を表します。
 // `{{...}}` に相当します。
 type ActionNode struct {
-	Token token.Token // The '{' (LDELIM) token
-	Pipe  Node        // 評価されるべき式のパイプライン（将来の拡張用）
+	Token    token.Token // The '{' (LDELIM) token
+	Pipe     Node        // 評価されるべき式のパイプライン（将来の拡張用）
+	NoFilter bool        // {$var nofilter} の場合は自動エスケープしない
+	Escapers []string    // 文脈に応じたエスケープ関数 (escape.Tree が設定する)
 }
 
 func (an *ActionNode) TokenLiteral() string { return an.Token.Literal }
 func (an *ActionNode) String() string {
 	if an.Pipe != nil {
+		if an.NoFilter {
+			return "{" + an.Pipe.String() + " nofilter}"
+		}
 		return "{" + an.Pipe.String() + "}"
 	}
 	return ""
@@ -0,0 +1,34 @@
+package ast
+
+import (
+	"strings"
+
+	"github.com/szks-repo/gosmarty/token"
+)
+
+// CallExpression は {format_price($p, "JPY")} のような関数呼び出しを表します
+type CallExpression struct {
+	Token    token.Token // The function name token
+	Function *Identifier // 呼び出す関数名
+	Args     []Node      // 引数
+}
+
+func (ce *CallExpression) TokenLiteral() string {
+	return ce.Token.Literal
+}
+
+func (ce *CallExpression) String() string {
+	var out strings.Builder
+
+	args := make([]string, len(ce.Args))
+	for i, arg := range ce.Args {
+		args[i] = arg.String()
+	}
+
+	out.WriteString(ce.Function.Value)
+	out.WriteString("(")
+	out.WriteString(strings.Join(args, ", "))
+	out.WriteString(")")
+
+	return out.String()
+}
@@ -0,0 +1,33 @@
+package ast
+
+import "github.com/szks-repo/gosmarty/token"
+
+// BooleanLiteral は true や false を表します
+type BooleanLiteral struct {
+	Token token.Token // The token.IDENT token
+	Value bool
+}
+
+func (bl *BooleanLiteral) TokenLiteral() string {
+	return bl.Token.Literal
+}
+
+func (bl *BooleanLiteral) String() string {
+	if bl.Value {
+		return "true"
+	}
+	return "false"
+}
+
+// NullLiteral は null を表します
+type NullLiteral struct {
+	Token token.Token // The token.IDENT token
+}
+
+func (nl *NullLiteral) TokenLiteral() string {
+	return nl.Token.Literal
+}
+
+func (nl *NullLiteral) String() string {
+	return "null"
+}
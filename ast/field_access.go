@@ -8,7 +8,7 @@ import (
 
 // MemberAccess は {$obj.prop} のようなプロパティアクセスを表します
 type FieldAccess struct {
-	Token token.Token // The '.' token
+	Token token.Token // The '.' or '->' token
 	Left  Node        // ドットの左側にあるオブジェクト (Identifier or another FieldAccess)
 	Right *Identifier // アクセスされるプロパティ
 }
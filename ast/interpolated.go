@@ -0,0 +1,35 @@
+package ast
+
+import (
+	"strings"
+
+	"github.com/szks-repo/gosmarty/token"
+)
+
+// InterpolatedString は "Hello $name" や "/img/{$item.id}.png" のような、変数が埋め込まれた文字列を表します
+type InterpolatedString struct {
+	Token token.Token // The token.INTERP token
+	Parts []Node      // 文字列の部分 (StringLiteral) と埋め込まれた式
+}
+
+func (is *InterpolatedString) TokenLiteral() string {
+	return is.Token.Literal
+}
+
+func (is *InterpolatedString) String() string {
+	var out strings.Builder
+
+	out.WriteString(`"`)
+	for _, part := range is.Parts {
+		if lit, ok := part.(*StringLiteral); ok {
+			out.WriteString(lit.Value)
+			continue
+		}
+		out.WriteString("{")
+		out.WriteString(part.String())
+		out.WriteString("}")
+	}
+	out.WriteString(`"`)
+
+	return out.String()
+}
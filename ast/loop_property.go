@@ -0,0 +1,18 @@
+package ast
+
+import "github.com/szks-repo/gosmarty/token"
+
+// LoopProperty は {$item@index} のような、{foreach} の要素変数から参照するループの状態を表します。
+type LoopProperty struct {
+	Token    token.Token // The '@' token
+	Item     *Identifier // {foreach} の item に指定した変数
+	Property *Identifier // index, iteration, first, last, total
+}
+
+func (lp *LoopProperty) TokenLiteral() string {
+	return lp.Token.Literal
+}
+
+func (lp *LoopProperty) String() string {
+	return lp.Item.String() + "@" + lp.Property.Value
+}
@@ -0,0 +1,38 @@
+package ast
+
+import (
+	"strings"
+
+	"github.com/szks-repo/gosmarty/token"
+)
+
+// MethodCall は {$user->getFullName()} のようなメソッド呼び出しを表します
+type MethodCall struct {
+	Token    token.Token // The '->' token
+	Receiver Node        // メソッドを持つオブジェクト
+	Method   *Identifier // 呼び出すメソッド名
+	Args     []Node      // 引数
+}
+
+func (mc *MethodCall) TokenLiteral() string {
+	return mc.Token.Literal
+}
+
+func (mc *MethodCall) String() string {
+	var out strings.Builder
+
+	args := make([]string, len(mc.Args))
+	for i, arg := range mc.Args {
+		args[i] = arg.String()
+	}
+
+	out.WriteString("(")
+	out.WriteString(mc.Receiver.String())
+	out.WriteString("->")
+	out.WriteString(mc.Method.Value)
+	out.WriteString("(")
+	out.WriteString(strings.Join(args, ", "))
+	out.WriteString("))")
+
+	return out.String()
+}
@@ -1,12 +1,20 @@
 package ast
 
-import "github.com/szks-repo/gosmarty/token"
+import (
+	"strings"
 
-// PipeNode は {$left | right} のようなパイプライン式を表します
+	"github.com/szks-repo/gosmarty/token"
+)
+
+// PipeNode は {$left | right:arg1:arg2} のようなパイプライン式を表します
 type PipeNode struct {
 	Token    token.Token // The '|' token
 	Left     Node        // パイプの左辺（値を提供する式）
 	Function *Identifier // 適用する関数（修飾子）
+	Args     []Node      // ':' で区切られた修飾子の引数
+	// {$items|@count} のように '@' が付いている場合は配列そのものに修飾子を適用する
+	// 付いていない場合、配列には要素ごとに適用する (Smarty 2 と同じ)
+	ApplyToArray bool
 }
 
 func (pn *PipeNode) TokenLiteral() string {
@@ -15,5 +23,15 @@ func (pn *PipeNode) TokenLiteral() string {
 
 func (pn *PipeNode) String() string {
 	// デバッグ用の実装
-	return "(" + pn.Left.String() + " | " + pn.Function.String() + ")"
+	var out strings.Builder
+	out.WriteString("(" + pn.Left.String() + " | ")
+	if pn.ApplyToArray {
+		out.WriteString("@")
+	}
+	out.WriteString(pn.Function.String())
+	for _, arg := range pn.Args {
+		out.WriteString(":" + arg.String())
+	}
+	out.WriteString(")")
+	return out.String()
 }
@@ -0,0 +1,17 @@
+package ast
+
+import "github.com/szks-repo/gosmarty/token"
+
+// StringLiteral は "foo" や 'bar' のような文字列リテラルを表します
+type StringLiteral struct {
+	Token token.Token // The token.STRING token
+	Value string
+}
+
+func (sl *StringLiteral) TokenLiteral() string {
+	return sl.Token.Literal
+}
+
+func (sl *StringLiteral) String() string {
+	return `"` + sl.Value + `"`
+}
@@ -0,0 +1,189 @@
+package gosmarty
+
+import (
+	"fmt"
+	"reflect"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/szks-repo/gosmarty/ast"
+	"github.com/szks-repo/gosmarty/modifier"
+	"github.com/szks-repo/gosmarty/object"
+)
+
+var errorInterface = reflect.TypeFor[error]()
+
+// evalMethodCall は {$user->getFullName()} のようなGoのメソッド呼び出しを評価する
+//
+// メソッドを呼び出せるのは WithLazyVariable で設定した構造体だけです。
+// WithVariable で設定した構造体はマップに変換され、メソッドを持ちません。
+func evalMethodCall(node *ast.MethodCall, sc *scope) object.Object {
+	receiver := unwrapOptional(eval(node.Receiver, sc))
+	if isError(receiver) {
+		return receiver
+	}
+	if receiver == nil || receiver.Type() == object.NullType {
+		return NULL
+	}
+
+	name := node.Method.Value
+	pos := node.Method.Token.Pos()
+	st, ok := receiver.(*object.LazyStruct)
+	if !ok {
+		if receiver.Type() == object.MapType {
+			return object.NewError("%s: cannot call method %s on map value (set structs with WithLazyVariable to call their methods)", pos, name)
+		}
+		return object.NewError("%s: cannot call method %s on %s value", pos, name, receiver.Type())
+	}
+
+	// テンプレートでは getFullName のように書けるよう、先頭を大文字にしたGoのメソッドを探す
+	goName := exportedName(name)
+	typ := reflect.Indirect(st.Value()).Type()
+	if !sc.engine.methodAllowed(typ, goName) {
+		return object.NewError("%s: method %s.%s is not allowed", pos, typ, goName)
+	}
+	method, ok := st.Method(goName)
+	if !ok {
+		return object.NewError("%s: %s has no method %s", pos, typ, goName)
+	}
+
+	args, errObj := evalArguments(node.Args, sc)
+	if errObj != nil {
+		return errObj
+	}
+	return withPos(pos, callFunc(method, args, fmt.Sprintf("%s.%s", typ, goName)))
+}
+
+// evalCallExpression は Funcs で登録されたGoの関数か、組み込みの関数の呼び出しを評価する
+func evalCallExpression(node *ast.CallExpression, sc *scope) object.Object {
+	name := node.Function.Value
+	pos := node.Function.Token.Pos()
+	fn, ok := sc.engine.function(name)
+	if !ok {
+		if builtin, ok := lookupBuiltinFunction(name); ok {
+			return builtin(node, sc)
+		}
+		return object.NewError("%s: function %s is not defined", pos, name)
+	}
+
+	args, errObj := evalArguments(node.Args, sc)
+	if errObj != nil {
+		return errObj
+	}
+	return withPos(pos, callFunc(fn, args, name))
+}
+
+// withPos は関数の呼び出しで発生したエラーに、テンプレート上の位置を付ける
+func withPos(pos string, result object.Object) object.Object {
+	if errObj, ok := result.(*object.Error); ok {
+		return object.NewError("%s: %s", pos, errObj.Message)
+	}
+	return result
+}
+
+func evalArguments(nodes []ast.Node, sc *scope) ([]object.Object, object.Object) {
+	args := make([]object.Object, len(nodes))
+	for i, node := range nodes {
+		evaluated := eval(node, sc)
+		if isError(evaluated) {
+			return nil, evaluated
+		}
+		args[i] = evaluated
+	}
+	return args, nil
+}
+
+// callFunc は引数を関数の型に合わせて変換してGoの関数を呼び出し、結果をオブジェクトに変換する
+//
+// 関数は値を返さないか、1つの値を返すか、値と error を返す必要があります。
+// error が nil でない場合は object.Error を返します。
+func callFunc(fn reflect.Value, args []object.Object, name string) object.Object {
+	ft := fn.Type()
+	numIn := ft.NumIn()
+	if err := funcArity(ft).Check(len(args)); err != nil {
+		return object.NewError("%s: %s", name, err)
+	}
+
+	in := make([]reflect.Value, len(args))
+	for i, arg := range args {
+		var argType reflect.Type
+		if ft.IsVariadic() && i >= numIn-1 {
+			argType = ft.In(numIn - 1).Elem()
+		} else {
+			argType = ft.In(i)
+		}
+		v, err := object.ToGo(arg, argType)
+		if err != nil {
+			return object.NewError("%s: argument %d: %s", name, i+1, err)
+		}
+		in[i] = v
+	}
+
+	out, err := safeCall(fn, in)
+	if err != nil {
+		return object.NewError("%s: %s", name, err)
+	}
+	if len(out) > 0 && ft.Out(len(out)-1) == errorInterface {
+		if err, _ := out[len(out)-1].Interface().(error); err != nil {
+			return object.NewError("%s: %s", name, err)
+		}
+		out = out[:len(out)-1]
+	}
+
+	switch len(out) {
+	case 0:
+		return NULL
+	case 1:
+		obj, err := object.Wrap(out[0].Interface())
+		if err != nil {
+			return object.NewError("%s: %s", name, err)
+		}
+		return obj
+	default:
+		return object.NewError("%s: too many return values", name)
+	}
+}
+
+// safeCall は fn を呼び出す
+// text/template と同じく、関数がパニックした場合は実行全体を止めずにエラーとして返す
+func safeCall(fn reflect.Value, in []reflect.Value) (out []reflect.Value, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if e, ok := r.(error); ok {
+				err = fmt.Errorf("panic: %w", e)
+			} else {
+				err = fmt.Errorf("panic: %v", r)
+			}
+		}
+	}()
+	return fn.Call(in), nil
+}
+
+// checkFunc は fn が callFunc で呼び出せる関数かどうかを確認する
+func checkFunc(fn reflect.Value) error {
+	if !fn.IsValid() || fn.Kind() != reflect.Func {
+		return fmt.Errorf("value of type %s is not a function", fn.Kind())
+	}
+	ft := fn.Type()
+	switch {
+	case ft.NumOut() <= 1:
+		return nil
+	case ft.NumOut() == 2 && ft.Out(1) == errorInterface:
+		return nil
+	default:
+		return fmt.Errorf("function of type %s must return a single value or a value and an error", ft)
+	}
+}
+
+// funcArity は関数が受け取る引数の数を返す
+func funcArity(ft reflect.Type) modifier.Arity {
+	if ft.IsVariadic() {
+		return modifier.Arity{Min: ft.NumIn() - 1, Max: -1}
+	}
+	return modifier.Arity{Min: ft.NumIn(), Max: ft.NumIn()}
+}
+
+func exportedName(name string) string {
+	r, size := utf8.DecodeRuneInString(name)
+	return string(unicode.ToUpper(r)) + name[size:]
+}
@@ -0,0 +1,210 @@
+package gosmarty
+
+import (
+	"cmp"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/szks-repo/gosmarty/object"
+)
+
+// PHP 8 の緩やかな比較 (==, <, >) の規則
+// https://www.php.net/manual/en/language.operators.comparison.php
+//
+//	null と string     -> null を "" として文字列で比較
+//	bool か null と任意 -> 両辺を真偽値に変換して比較
+//	数値と数値形式の文字列 -> 数値として比較
+//	数値と文字列       -> 数値を文字列に変換して比較
+//	配列と配列         -> 要素の数、同じキーの値の順に比較
+//	配列と任意         -> 配列が常に大きい
+
+// looseCompare は PHP の規則で left と right を比較し、-1, 0, 1 のいずれかを返す
+// 比較できない場合は false を返す
+func looseCompare(left, right object.Object) (int, bool) {
+	if _, ok := left.(object.Comparable); ok {
+		return compareObjects(left, right)
+	}
+	if _, ok := right.(object.Comparable); ok {
+		return compareObjects(left, right)
+	}
+
+	ls, lIsStr := stringValue(left)
+	rs, rIsStr := stringValue(right)
+	_, lIsNull := left.(*object.Null)
+	_, rIsNull := right.(*object.Null)
+	_, lIsBool := left.(*object.Boolean)
+	_, rIsBool := right.(*object.Boolean)
+
+	switch {
+	case lIsNull && rIsStr:
+		return compareStrings("", rs), true
+	case lIsStr && rIsNull:
+		return compareStrings(ls, ""), true
+	case lIsNull || rIsNull || lIsBool || rIsBool:
+		return cmp.Compare(boolInt(phpTruthy(left)), boolInt(phpTruthy(right))), true
+	case lIsStr && rIsStr:
+		return compareStrings(ls, rs), true
+	case object.IsNumeric(left) && rIsStr:
+		if num, ok := numericString(rs); ok {
+			return object.CompareNumbers(left, num)
+		}
+		return strings.Compare(left.Inspect(), rs), true
+	case lIsStr && object.IsNumeric(right):
+		if num, ok := numericString(ls); ok {
+			return object.CompareNumbers(num, right)
+		}
+		return strings.Compare(ls, right.Inspect()), true
+	}
+
+	lArr, lIsArr := arrayPairs(left)
+	rArr, rIsArr := arrayPairs(right)
+	switch {
+	case lIsArr && rIsArr:
+		return compareArrays(lArr, rArr)
+	case lIsArr:
+		return 1, true
+	case rIsArr:
+		return -1, true
+	}
+
+	return compareObjects(left, right)
+}
+
+// looseEqual は PHP の == と同じ規則で left と right が等しいかを返す
+func looseEqual(left, right object.Object) bool {
+	if order, ok := looseCompare(left, right); ok {
+		return order == 0
+	}
+	return objectsEqual(left, right)
+}
+
+// identical は PHP の === と同じく、型と値が等しいかを返す
+// 配列は同じキーと値の組を同じ順序で持つ場合に等しい
+func identical(left, right object.Object) bool {
+	lArr, lIsArr := arrayPairs(left)
+	rArr, rIsArr := arrayPairs(right)
+	if lIsArr || rIsArr {
+		if !lIsArr || !rIsArr || len(lArr.keys) != len(rArr.keys) {
+			return false
+		}
+		for i, key := range lArr.keys {
+			if rArr.keys[i] != key || !identical(lArr.values[key], rArr.values[key]) {
+				return false
+			}
+		}
+		return true
+	}
+
+	if left.Type() != right.Type() {
+		return false
+	}
+	return objectsEqual(left, right)
+}
+
+// phpTruthy は PHP の (bool) 変換と同じく、"0" も偽として扱う
+func phpTruthy(obj object.Object) bool {
+	if s, ok := stringValue(obj); ok && s == "0" {
+		return false
+	}
+	return isTruthy(obj)
+}
+
+// compareStrings は数値形式の文字列どうしは数値として、それ以外は辞書順で比較する
+func compareStrings(left, right string) int {
+	if l, ok := numericString(left); ok {
+		if r, ok := numericString(right); ok {
+			order, _ := object.CompareNumbers(l, r)
+			return order
+		}
+	}
+	return strings.Compare(left, right)
+}
+
+// numericPattern は PHP の数値形式の文字列 (前後の空白を許す)
+var numericPattern = regexp.MustCompile(`^[ \t\n\r\v\f]*[+-]?(\d+(\.\d*)?|\.\d+)([eE][+-]?\d+)?[ \t\n\r\v\f]*$`)
+
+// numericString は PHP の数値形式の文字列を Integer か Decimal に変換する
+func numericString(s string) (object.Object, bool) {
+	if !numericPattern.MatchString(s) {
+		return nil, false
+	}
+	s = strings.TrimSpace(s)
+	if i, ok := new(big.Int).SetString(strings.TrimPrefix(s, "+"), 10); ok {
+		return &object.Integer{Value: i}, true
+	}
+	if d, ok := object.ParseDecimal(s); ok {
+		return d, true
+	}
+	return nil, false
+}
+
+// stringValue は String と HTML の文字列を返す
+func stringValue(obj object.Object) (string, bool) {
+	switch obj := obj.(type) {
+	case *object.String:
+		return obj.Value, true
+	case *object.HTML:
+		return obj.Value, true
+	default:
+		return "", false
+	}
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// pairs は配列とマップを、キーと値の組として比較するための表現
+type pairs struct {
+	keys   []string
+	values map[string]object.Object
+}
+
+func arrayPairs(obj object.Object) (pairs, bool) {
+	switch obj := obj.(type) {
+	case object.ArrayLike:
+		p := pairs{keys: make([]string, obj.Len()), values: make(map[string]object.Object, obj.Len())}
+		for i := range obj.Len() {
+			key := strconv.Itoa(i)
+			p.keys[i] = key
+			p.values[key], _ = obj.At(i)
+		}
+		return p, true
+	case object.MapLike:
+		keys := object.OrderedKeys(obj)
+		p := pairs{keys: keys, values: make(map[string]object.Object, len(keys))}
+		for _, key := range keys {
+			p.values[key], _ = obj.Get(key)
+		}
+		return p, true
+	default:
+		return pairs{}, false
+	}
+}
+
+// compareArrays は要素の少ない方を小さいとし、同じ数であれば left のキーの順に値を比較する
+// left のキーが right にない場合は比較できない
+func compareArrays(left, right pairs) (int, bool) {
+	if order := cmp.Compare(len(left.keys), len(right.keys)); order != 0 {
+		return order, true
+	}
+	for _, key := range left.keys {
+		r, ok := right.values[key]
+		if !ok {
+			return 0, false
+		}
+		order, ok := looseCompare(left.values[key], r)
+		if !ok {
+			return 0, false
+		}
+		if order != 0 {
+			return order, true
+		}
+	}
+	return 0, true
+}
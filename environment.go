@@ -1,24 +1,42 @@
 package gosmarty
 
 import (
-	"github.com/szks-repo/gosmarty/modifier"
+	"errors"
+	"fmt"
+
 	"github.com/szks-repo/gosmarty/object"
 )
 
 // Environment は変数名と、それが束縛する値(Object)を保持します。
+// NewEnvironment で作成した後は読み取り専用として扱われるため、
+// サイト全体で共有するデータを1つの Environment にまとめ、複数のゴルーチンから同時にレンダリングできます。
 type Environment struct {
-	vars      map[string]object.Object
-	modifiers map[string]modifier.Modifier
+	vars   map[string]object.Object
+	strict bool
 }
 
+// NewEnvironment はオプションを順に適用して Environment を作成します。
+//
+// 変換できない値などでオプションが失敗しても残りのオプションは適用され、
+// すべてのエラーを errors.Join でまとめて、作成した Environment とともに返します。
+// WithStrict を指定した場合は、エラーがあれば Environment を作成せずに nil を返します。
 func NewEnvironment(opt ...EnvOption) (*Environment, error) {
 	env := &Environment{
 		vars: make(map[string]object.Object),
 	}
+	var errs []error
 	for _, fn := range opt {
-		_ = fn(env) //todo: reporting all errors
+		if err := fn(env); err != nil {
+			errs = append(errs, err)
+		}
 	}
 
+	if err := errors.Join(errs...); err != nil {
+		if env.strict {
+			return nil, err
+		}
+		return env, err
+	}
 	return env, nil
 }
 
@@ -27,21 +45,56 @@ func (e *Environment) GetVar(name string) (object.Object, bool) {
 	return obj, ok
 }
 
+// setVar は NewEnvironment のオプションからのみ呼び出されます。
 func (e *Environment) setVar(name string, val object.Object) {
 	e.vars[name] = val
 }
 
-func (e *Environment) unsetVar(name string) {
-	delete(e.vars, name)
-}
-
 type EnvOption = func(env *Environment) error
 
+// WithStrict は、変換できない値を含む Environment の作成を拒否します。
+func WithStrict() EnvOption {
+	return func(env *Environment) error {
+		env.strict = true
+		return nil
+	}
+}
+
+// WithVariable は値を object.NewObjectFromAny で変換して変数に設定します。
+// 構造体はマップに変換されメソッドを持たないため、{$obj->method()} で呼び出す場合は WithLazyVariable を使用してください。
 func WithVariable(name string, value any) EnvOption {
 	return func(env *Environment) error {
 		obj, err := object.NewObjectFromAny(value)
 		if err != nil {
-			return err
+			return fmt.Errorf("variable %q: %w", name, err)
+		}
+
+		env.setVar(name, obj)
+		return nil
+	}
+}
+
+// WithLazyVariable は値を object.Wrap で包んで変数に設定します。
+// 構造体やマップ、スライスはテンプレートから参照された部分だけが変換されます。
+func WithLazyVariable(name string, value any) EnvOption {
+	return func(env *Environment) error {
+		obj, err := object.Wrap(value)
+		if err != nil {
+			return fmt.Errorf("variable %q: %w", name, err)
+		}
+
+		env.setVar(name, obj)
+		return nil
+	}
+}
+
+// WithJSONVariable はJSONを変数に設定します。
+// オブジェクトのキーの順序は保たれ、{foreach} でもJSONと同じ順に走査されます。
+func WithJSONVariable(name string, data []byte) EnvOption {
+	return func(env *Environment) error {
+		obj, err := object.FromJSON(data)
+		if err != nil {
+			return fmt.Errorf("variable %q: %w", name, err)
 		}
 
 		env.setVar(name, obj)
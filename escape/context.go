@@ -0,0 +1,453 @@
+package escape
+
+import (
+	"strings"
+)
+
+// state はテキストノードを走査した時点でのHTMLの構文上の位置を表します。
+type state uint8
+
+const (
+	stateText        state = iota // 要素の本文
+	stateTag                      // タグの中で、属性名の前
+	stateAttrName                 // 属性名の途中
+	stateAfterName                // 属性名の後 ('=' の前)
+	stateBeforeValue              // '=' の後、属性値の前
+	stateAttr                     // 属性値の中
+	stateRCDATA                   // <textarea> や <title> の本文
+	stateScript                   // <script> の本文
+	stateStyle                    // <style> の本文
+	stateComment                  // <!-- ... --> の中
+)
+
+// attrType は属性値の中身の種類を表します。
+type attrType uint8
+
+const (
+	attrNormal attrType = iota
+	attrURL             // href, src など
+	attrJS              // onclick などのイベントハンドラ
+	attrCSS             // style
+)
+
+// delim は属性値の区切り文字を表します。
+type delim uint8
+
+const (
+	delimDoubleQuote delim = iota
+	delimSingleQuote
+	delimSpace // クォートなしの属性値
+)
+
+// urlPart はURL属性の値のどこにいるかを表します。
+type urlPart uint8
+
+const (
+	urlPartNone     urlPart = iota // 値の先頭 (スキームを含みうる)
+	urlPartPreQuery                // パス部分
+	urlPartQuery                   // '?' または '#' の後
+)
+
+// jsState はJavaScriptの字句上の位置を表します。
+type jsState uint8
+
+const (
+	jsExpr jsState = iota
+	jsDqStr
+	jsSqStr
+	jsTmplLit
+	jsLineComment
+	jsBlockComment
+)
+
+// cssState はCSSの字句上の位置を表します。
+type cssState uint8
+
+const (
+	cssValue cssState = iota
+	cssDqStr
+	cssSqStr
+	cssComment
+)
+
+// context はアクションの出力先となるHTMLの文脈です。
+// 同じ文脈どうしは == で比較できます。
+type context struct {
+	state   state
+	element string // 本文の扱いが特殊な要素 (script, style, textarea, title)
+	attr    attrType
+	delim   delim
+	urlPart urlPart
+	js      jsState
+	css     cssState
+}
+
+// transition はテキスト s を読み進めた後の文脈を返します。
+func transition(c context, s string) context {
+	for len(s) > 0 {
+		c, s = step(c, s)
+	}
+	return c
+}
+
+func step(c context, s string) (context, string) {
+	switch c.state {
+	case stateText:
+		return tText(c, s)
+	case stateTag:
+		return tTag(c, s)
+	case stateAttrName:
+		return tAttrName(c, s)
+	case stateAfterName:
+		return tAfterName(c, s)
+	case stateBeforeValue:
+		return tBeforeValue(c, s)
+	case stateAttr:
+		return tAttr(c, s)
+	case stateRCDATA:
+		return tSpecialBody(c, s)
+	case stateScript:
+		return tSpecialBody(c, s)
+	case stateStyle:
+		return tSpecialBody(c, s)
+	case stateComment:
+		return tComment(c, s)
+	}
+	return c, ""
+}
+
+func tText(c context, s string) (context, string) {
+	i := strings.IndexByte(s, '<')
+	if i < 0 {
+		return c, ""
+	}
+	rest := s[i+1:]
+	if strings.HasPrefix(rest, "!--") {
+		return context{state: stateComment}, rest[3:]
+	}
+
+	closing := strings.HasPrefix(rest, "/")
+	if closing {
+		rest = rest[1:]
+	}
+	n := 0
+	for n < len(rest) && isTagNameChar(rest[n], n == 0) {
+		n++
+	}
+	if n == 0 {
+		// タグではない '<'
+		return c, rest
+	}
+
+	next := context{state: stateTag}
+	if !closing {
+		next.element = specialElement(rest[:n])
+	}
+	return next, rest[n:]
+}
+
+func tTag(c context, s string) (context, string) {
+	s = strings.TrimLeft(s, " \t\n\r\f")
+	if s == "" {
+		return c, ""
+	}
+
+	switch s[0] {
+	case '>':
+		return bodyContext(c.element), s[1:]
+	case '/':
+		return c, s[1:]
+	}
+
+	n := attrNameLen(s)
+	c.attr = attributeType(s[:n])
+	if n == len(s) {
+		// 属性名が次のノードに続く可能性がある
+		c.state = stateAttrName
+		return c, ""
+	}
+	c.state = stateAfterName
+	return c, s[n:]
+}
+
+func tAttrName(c context, s string) (context, string) {
+	n := attrNameLen(s)
+	if n == len(s) {
+		return c, ""
+	}
+	c.state = stateAfterName
+	return c, s[n:]
+}
+
+func tAfterName(c context, s string) (context, string) {
+	s = strings.TrimLeft(s, " \t\n\r\f")
+	if s == "" {
+		return c, ""
+	}
+	if s[0] == '=' {
+		c.state = stateBeforeValue
+		return c, s[1:]
+	}
+	// 値のない属性 (e.g., <input disabled>)
+	c.state = stateTag
+	c.attr = attrNormal
+	return c, s
+}
+
+func tBeforeValue(c context, s string) (context, string) {
+	s = strings.TrimLeft(s, " \t\n\r\f")
+	if s == "" {
+		return c, ""
+	}
+
+	c = enterAttrValue(c)
+	switch s[0] {
+	case '"':
+		c.delim = delimDoubleQuote
+		return c, s[1:]
+	case '\'':
+		c.delim = delimSingleQuote
+		return c, s[1:]
+	case '>':
+		c.state = stateTag
+		c.attr = attrNormal
+		return c, s
+	default:
+		c.delim = delimSpace
+		return c, s
+	}
+}
+
+func tAttr(c context, s string) (context, string) {
+	end := -1
+	switch c.delim {
+	case delimDoubleQuote:
+		end = strings.IndexByte(s, '"')
+	case delimSingleQuote:
+		end = strings.IndexByte(s, '\'')
+	case delimSpace:
+		end = strings.IndexAny(s, " \t\n\r\f>")
+	}
+
+	value := s
+	if end >= 0 {
+		value = s[:end]
+	}
+	c = attrValueTransition(c, value)
+	if end < 0 {
+		return c, ""
+	}
+
+	rest := s[end:]
+	if c.delim != delimSpace {
+		rest = rest[1:]
+	}
+	return context{state: stateTag, element: c.element}, rest
+}
+
+// tSpecialBody は <script>, <style>, <textarea>, <title> の本文を閉じタグまで読み進めます。
+func tSpecialBody(c context, s string) (context, string) {
+	end := indexFold(s, "</"+c.element)
+	body := s
+	if end >= 0 {
+		body = s[:end]
+	}
+
+	switch c.state {
+	case stateScript:
+		c.js = jsTransition(c.js, body)
+	case stateStyle:
+		c.css = cssTransition(c.css, body)
+	}
+	if end < 0 {
+		return c, ""
+	}
+	return context{state: stateTag}, s[end+2+len(c.element):]
+}
+
+func tComment(c context, s string) (context, string) {
+	i := strings.Index(s, "-->")
+	if i < 0 {
+		return c, ""
+	}
+	return context{state: stateText}, s[i+3:]
+}
+
+// enterAttrValue は属性値の先頭の文脈を返します。
+func enterAttrValue(c context) context {
+	c.state = stateAttr
+	c.urlPart = urlPartNone
+	c.js = jsExpr
+	c.css = cssValue
+	return c
+}
+
+func attrValueTransition(c context, value string) context {
+	switch c.attr {
+	case attrURL:
+		c.urlPart = urlTransition(c.urlPart, value)
+	case attrJS:
+		c.js = jsTransition(c.js, value)
+	case attrCSS:
+		c.css = cssTransition(c.css, value)
+	}
+	return c
+}
+
+func urlTransition(part urlPart, s string) urlPart {
+	if s == "" {
+		return part
+	}
+	if strings.ContainsAny(s, "?#") {
+		return urlPartQuery
+	}
+	if part == urlPartNone {
+		return urlPartPreQuery
+	}
+	return part
+}
+
+func jsTransition(js jsState, s string) jsState {
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		switch js {
+		case jsExpr:
+			switch {
+			case ch == '"':
+				js = jsDqStr
+			case ch == '\'':
+				js = jsSqStr
+			case ch == '`':
+				js = jsTmplLit
+			case ch == '/' && i+1 < len(s) && s[i+1] == '/':
+				js = jsLineComment
+				i++
+			case ch == '/' && i+1 < len(s) && s[i+1] == '*':
+				js = jsBlockComment
+				i++
+			}
+		case jsDqStr, jsSqStr, jsTmplLit:
+			switch {
+			case ch == '\\':
+				i++
+			case ch == '"' && js == jsDqStr, ch == '\'' && js == jsSqStr, ch == '`' && js == jsTmplLit:
+				js = jsExpr
+			}
+		case jsLineComment:
+			if ch == '\n' {
+				js = jsExpr
+			}
+		case jsBlockComment:
+			if ch == '*' && i+1 < len(s) && s[i+1] == '/' {
+				js = jsExpr
+				i++
+			}
+		}
+	}
+	return js
+}
+
+func cssTransition(css cssState, s string) cssState {
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		switch css {
+		case cssValue:
+			switch {
+			case ch == '"':
+				css = cssDqStr
+			case ch == '\'':
+				css = cssSqStr
+			case ch == '/' && i+1 < len(s) && s[i+1] == '*':
+				css = cssComment
+				i++
+			}
+		case cssDqStr, cssSqStr:
+			switch {
+			case ch == '\\':
+				i++
+			case ch == '"' && css == cssDqStr, ch == '\'' && css == cssSqStr:
+				css = cssValue
+			}
+		case cssComment:
+			if ch == '*' && i+1 < len(s) && s[i+1] == '/' {
+				css = cssValue
+				i++
+			}
+		}
+	}
+	return css
+}
+
+// bodyContext は開始タグを閉じた後の本文の文脈を返します。
+func bodyContext(element string) context {
+	switch element {
+	case "script":
+		return context{state: stateScript, element: element}
+	case "style":
+		return context{state: stateStyle, element: element}
+	case "textarea", "title":
+		return context{state: stateRCDATA, element: element}
+	default:
+		return context{state: stateText}
+	}
+}
+
+func specialElement(name string) string {
+	name = strings.ToLower(name)
+	switch name {
+	case "script", "style", "textarea", "title":
+		return name
+	default:
+		return ""
+	}
+}
+
+// attributeType は属性名から属性値の種類を判定します。
+func attributeType(name string) attrType {
+	name = strings.ToLower(name)
+	name = strings.TrimPrefix(name, "data-")
+	if i := strings.IndexByte(name, ':'); i >= 0 {
+		name = name[i+1:]
+	}
+
+	switch {
+	case strings.HasPrefix(name, "on"):
+		return attrJS
+	case name == "style":
+		return attrCSS
+	}
+	switch name {
+	case "href", "src", "action", "formaction", "cite", "poster", "background",
+		"longdesc", "usemap", "codebase", "data", "manifest", "icon", "srcset", "ping":
+		return attrURL
+	}
+	if strings.Contains(name, "src") || strings.Contains(name, "uri") || strings.Contains(name, "url") {
+		return attrURL
+	}
+	return attrNormal
+}
+
+func isTagNameChar(ch byte, first bool) bool {
+	if 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' {
+		return true
+	}
+	return !first && ('0' <= ch && ch <= '9' || ch == '-' || ch == ':')
+}
+
+func attrNameLen(s string) int {
+	n := strings.IndexAny(s, " \t\n\r\f=>/")
+	if n < 0 {
+		return len(s)
+	}
+	return n
+}
+
+// indexFold は大文字小文字を区別せずに substr の位置を探します。
+func indexFold(s, substr string) int {
+	for i := 0; i+len(substr) <= len(s); i++ {
+		if strings.EqualFold(s[i:i+len(substr)], substr) {
+			return i
+		}
+	}
+	return -1
+}
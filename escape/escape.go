@@ -0,0 +1,210 @@
+// Package escape は html/template と同様に、HTMLの文脈に応じたエスケープをテンプレートに適用します。
+//
+// Tree はパース済みの ast.Tree のテキストノードを走査してHTMLの構造を追跡し、
+// 各アクションの出力先の文脈 (要素の本文、属性値、URL、<script>、<style>、イベントハンドラ) に応じた
+// エスケープ関数を ast.ActionNode.Escapers に記録します。評価時には Apply でそれらを適用します。
+package escape
+
+import (
+	"fmt"
+
+	"github.com/szks-repo/gosmarty/ast"
+)
+
+// Tree はテンプレート全体の各アクションにエスケープ関数を割り当てます。
+// 分岐の終わりでHTMLの文脈が一致しない場合などはエラーを返します。
+func Tree(tree *ast.Tree) error {
+	_, err := escapeList(context{state: stateText}, tree.Root)
+	return err
+}
+
+func escapeList(c context, list *ast.ListNode) (context, error) {
+	if list == nil {
+		return c, nil
+	}
+	for _, node := range list.Nodes {
+		var err error
+		c, err = escapeNode(c, node)
+		if err != nil {
+			return c, err
+		}
+	}
+	return c, nil
+}
+
+func escapeNode(c context, node ast.Node) (context, error) {
+	switch node := node.(type) {
+	case *ast.TextNode:
+		return transition(c, node.Value), nil
+	case *ast.ActionNode:
+		return escapeAction(c, node)
+	case *ast.ListNode:
+		return escapeList(c, node)
+	case *ast.IfNode:
+		return escapeIf(c, node)
+	case *ast.ForeachNode:
+		return escapeForeach(c, node)
+	default:
+		// 出力を持たないノード
+		return c, nil
+	}
+}
+
+func escapeAction(c context, node *ast.ActionNode) (context, error) {
+	if c.state == stateBeforeValue {
+		// クォートなしの属性値の先頭
+		c = enterAttrValue(c)
+		c.delim = delimSpace
+	}
+
+	var names []string
+	switch c.state {
+	case stateText, stateRCDATA, stateComment:
+		names = []string{escHTML}
+	case stateTag, stateAttrName, stateAfterName:
+		names = []string{escAttrName}
+		c.state = stateAttrName
+		c.attr = attrNormal
+	case stateAttr:
+		var err error
+		names, err = attrEscapers(c)
+		if err != nil {
+			return c, fmt.Errorf("%s: %w", node.String(), err)
+		}
+		if c.attr == attrURL && c.urlPart == urlPartNone {
+			c.urlPart = urlPartPreQuery
+		}
+	case stateScript:
+		name, err := jsEscaper(c.js)
+		if err != nil {
+			return c, fmt.Errorf("%s: %w", node.String(), err)
+		}
+		names = []string{name}
+	case stateStyle:
+		name, err := cssEscaper(c.css)
+		if err != nil {
+			return c, fmt.Errorf("%s: %w", node.String(), err)
+		}
+		names = []string{name}
+	}
+
+	node.Escapers = names
+	return c, nil
+}
+
+// attrEscapers は属性値の中のアクションに適用するエスケープ関数を返します。
+func attrEscapers(c context) ([]string, error) {
+	attrEsc := escAttr
+	if c.delim == delimSpace {
+		attrEsc = escAttrUnquoted
+	}
+
+	switch c.attr {
+	case attrURL:
+		switch c.urlPart {
+		case urlPartNone:
+			return []string{escURLFilter, escURLNormalize, attrEsc}, nil
+		case urlPartPreQuery:
+			return []string{escURLNormalize, attrEsc}, nil
+		default:
+			return []string{escURLQuery, attrEsc}, nil
+		}
+	case attrJS:
+		name, err := jsEscaper(c.js)
+		if err != nil {
+			return nil, err
+		}
+		return []string{name, attrEsc}, nil
+	case attrCSS:
+		name, err := cssEscaper(c.css)
+		if err != nil {
+			return nil, err
+		}
+		return []string{name, attrEsc}, nil
+	default:
+		return []string{attrEsc}, nil
+	}
+}
+
+func jsEscaper(js jsState) (string, error) {
+	switch js {
+	case jsExpr:
+		return escJSValue, nil
+	case jsDqStr, jsSqStr:
+		return escJSString, nil
+	case jsTmplLit:
+		return "", fmt.Errorf("action in JavaScript template literal is not supported")
+	default:
+		return "", fmt.Errorf("action in JavaScript comment is not supported")
+	}
+}
+
+func cssEscaper(css cssState) (string, error) {
+	switch css {
+	case cssValue:
+		return escCSSValue, nil
+	case cssDqStr, cssSqStr:
+		return escCSSString, nil
+	default:
+		return "", fmt.Errorf("action in CSS comment is not supported")
+	}
+}
+
+func escapeIf(c context, node *ast.IfNode) (context, error) {
+	branches := []*ast.ListNode{node.Consequence}
+	for _, elseif := range node.ElseIfs {
+		branches = append(branches, elseif.Consequence)
+	}
+	// {else} がない場合は、何も出力しない分岐として扱う
+	branches = append(branches, node.Alternative)
+
+	return joinBranches(c, node.Token.Literal, branches, false)
+}
+
+func escapeForeach(c context, node *ast.ForeachNode) (context, error) {
+	// 本体は繰り返し実行されるため、開始時と同じ文脈で終わる必要がある
+	return joinBranches(c, node.Token.Literal, []*ast.ListNode{node.Body, node.Alternative}, true)
+}
+
+func joinBranches(c context, tag string, branches []*ast.ListNode, loop bool) (context, error) {
+	var joined context
+	for i, branch := range branches {
+		end, err := escapeList(c, branch)
+		if err != nil {
+			return c, err
+		}
+		if loop {
+			if _, ok := join(c, end); !ok {
+				return c, fmt.Errorf("{%s} body ends in a different HTML context from where it starts", tag)
+			}
+		}
+		if i > 0 {
+			var ok bool
+			if end, ok = join(joined, end); !ok {
+				return c, fmt.Errorf("{%s} branches end in different HTML contexts", tag)
+			}
+		}
+		joined = end
+	}
+	return joined, nil
+}
+
+// join は2つの分岐の終わりの文脈をそろえます。
+// <input {if $checked}checked{/if}> のように、属性名の直後とタグの中は同じ文脈として扱います。
+func join(a, b context) (context, bool) {
+	if a == b {
+		return a, true
+	}
+	if settle(a) == settle(b) {
+		return settle(a), true
+	}
+	return context{}, false
+}
+
+func settle(c context) context {
+	switch c.state {
+	case stateAttrName, stateAfterName:
+		return context{state: stateTag, element: c.element}
+	}
+	return c
+}
@@ -0,0 +1,241 @@
+package escape
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"strings"
+	"unicode"
+
+	"github.com/szks-repo/gosmarty/object"
+)
+
+// エスケープ関数の名前。ast.ActionNode.Escapers に記録されます。
+const (
+	escHTML           = "html"
+	escAttr           = "attr"
+	escAttrUnquoted   = "attr_unquoted"
+	escAttrName       = "attr_name"
+	escURLFilter      = "url_filter"
+	escURLNormalize   = "url_normalize"
+	escURLQuery       = "url_query"
+	escJSValue        = "js"
+	escJSString       = "js_str"
+	escCSSValue       = "css"
+	escCSSString      = "css_str"
+	unsafeReplacement = "ZgotmplZ" // html/template と同じく、危険な値の代わりに出力する文字列
+)
+
+type escaper func(obj object.Object) string
+
+var escapers = map[string]escaper{
+	escHTML:         escapeHTMLText,
+	escAttr:         stringEscaper(html.EscapeString),
+	escAttrUnquoted: stringEscaper(escapeAttrUnquoted),
+	escAttrName:     stringEscaper(filterAttrName),
+	escURLFilter:    stringEscaper(filterURL),
+	escURLNormalize: stringEscaper(normalizeURL),
+	escURLQuery:     stringEscaper(escapeURLQuery),
+	escJSValue:      escapeJSValue,
+	escJSString:     stringEscaper(escapeJSString),
+	escCSSValue:     stringEscaper(filterCSSValue),
+	escCSSString:    stringEscaper(escapeCSSString),
+}
+
+// Apply は Tree が記録したエスケープ関数を順に適用し、出力する文字列を返します。
+func Apply(names []string, obj object.Object) string {
+	if len(names) == 0 {
+		return obj.Inspect()
+	}
+	if obj.Type() == object.NullType && names[0] != escJSValue {
+		return ""
+	}
+
+	for i, name := range names {
+		esc, ok := escapers[name]
+		if !ok {
+			panic(fmt.Sprintf("escape: unknown escaper %q", name))
+		}
+		out := esc(obj)
+		if i == len(names)-1 {
+			return out
+		}
+		obj = object.NewString(out)
+	}
+	return ""
+}
+
+func stringEscaper(fn func(string) string) escaper {
+	return func(obj object.Object) string {
+		return fn(obj.Inspect())
+	}
+}
+
+// escapeHTMLText は要素の本文向けのエスケープです。安全な文字列 (object.HTML) はそのまま出力します。
+func escapeHTMLText(obj object.Object) string {
+	if obj.Type() == object.HTMLType {
+		return obj.Inspect()
+	}
+	return html.EscapeString(obj.Inspect())
+}
+
+func escapeAttrUnquoted(s string) string {
+	var out strings.Builder
+	for _, r := range s {
+		if r < utf8RuneSelf && !isAlnum(r) && !strings.ContainsRune("-_.:/", r) {
+			fmt.Fprintf(&out, "&#%d;", r)
+			continue
+		}
+		out.WriteRune(r)
+	}
+	return out.String()
+}
+
+// filterAttrName は属性名として安全な値だけを通します。
+// イベントハンドラや style のように、値の文脈が変わる属性名は拒否します。
+func filterAttrName(s string) string {
+	name := strings.ToLower(s)
+	if name == "" {
+		return unsafeReplacement
+	}
+	for _, r := range name {
+		if !isAlnum(r) && r != '-' && r != '_' && r != ':' {
+			return unsafeReplacement
+		}
+	}
+	if attributeType(name) != attrNormal {
+		return unsafeReplacement
+	}
+	return name
+}
+
+// filterURL は http, https, mailto 以外のスキームを持つURLを拒否します。
+func filterURL(s string) string {
+	if i := strings.IndexAny(s, ":/?#"); i >= 0 && s[i] == ':' {
+		scheme := strings.ToLower(s[:i])
+		if scheme != "http" && scheme != "https" && scheme != "mailto" {
+			return "#" + unsafeReplacement
+		}
+	}
+	return s
+}
+
+// normalizeURL はURLとして不正な文字をパーセントエンコードします。
+func normalizeURL(s string) string {
+	return percentEncode(s, func(b byte) bool {
+		return isUnreserved(b) || strings.IndexByte(":/?#[]@!$&'()*+,;=%", b) >= 0
+	})
+}
+
+// escapeURLQuery はクエリ文字列やフラグメントの値をパーセントエンコードします。
+func escapeURLQuery(s string) string {
+	return percentEncode(s, isUnreserved)
+}
+
+func percentEncode(s string, keep func(byte) bool) string {
+	var out strings.Builder
+	for i := 0; i < len(s); i++ {
+		b := s[i]
+		if keep(b) {
+			out.WriteByte(b)
+			continue
+		}
+		fmt.Fprintf(&out, "%%%02X", b)
+	}
+	return out.String()
+}
+
+// escapeJSValue はオブジェクトをJavaScriptの値 (JSON) として出力します。
+func escapeJSValue(obj object.Object) string {
+	v, err := object.JSONValue(obj)
+	if err != nil {
+		return "null"
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	if err := enc.Encode(v); err != nil {
+		return "null"
+	}
+	out := strings.TrimSuffix(buf.String(), "\n")
+	// json.Encoder は <, >, & をエスケープするが、属性値に埋め込まれる場合に備えて ' もエスケープする
+	return strings.ReplaceAll(out, "'", `\u0027`)
+}
+
+// escapeJSString はJavaScriptの文字列リテラルの中身としてエスケープします。
+func escapeJSString(s string) string {
+	var out strings.Builder
+	for _, r := range s {
+		switch r {
+		case '\\':
+			out.WriteString(`\\`)
+		case '\'':
+			out.WriteString(`\u0027`)
+		case '"':
+			out.WriteString(`\u0022`)
+		case '`':
+			out.WriteString(`\u0060`)
+		case '<':
+			out.WriteString(`\u003c`)
+		case '>':
+			out.WriteString(`\u003e`)
+		case '&':
+			out.WriteString(`\u0026`)
+		case '\n':
+			out.WriteString(`\n`)
+		case '\r':
+			out.WriteString(`\r`)
+		case '\t':
+			out.WriteString(`\t`)
+		case '\u2028', '\u2029':
+			fmt.Fprintf(&out, `\u%04x`, r)
+		default:
+			if r < 0x20 {
+				fmt.Fprintf(&out, `\u%04x`, r)
+				continue
+			}
+			out.WriteRune(r)
+		}
+	}
+	return out.String()
+}
+
+// filterCSSValue はCSSの値として安全な文字だけからなる値を通します。
+func filterCSSValue(s string) string {
+	lower := strings.ToLower(s)
+	if strings.Contains(lower, "expression") || strings.Contains(lower, "mozbinding") {
+		return unsafeReplacement
+	}
+	for _, r := range s {
+		if r >= utf8RuneSelf && unicode.IsLetter(r) {
+			continue
+		}
+		if !isAlnum(r) && !strings.ContainsRune("#%.,-_ ", r) {
+			return unsafeReplacement
+		}
+	}
+	return s
+}
+
+// escapeCSSString はCSSの文字列の中身として、英数字以外を16進エスケープします。
+func escapeCSSString(s string) string {
+	var out strings.Builder
+	for _, r := range s {
+		if r < utf8RuneSelf && !isAlnum(r) && r != ' ' && r != '-' && r != '_' && r != '.' {
+			fmt.Fprintf(&out, `\%x `, r)
+			continue
+		}
+		out.WriteRune(r)
+	}
+	return out.String()
+}
+
+const utf8RuneSelf = 0x80
+
+func isAlnum(r rune) bool {
+	return 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9'
+}
+
+func isUnreserved(b byte) bool {
+	return isAlnum(rune(b)) || b == '-' || b == '.' || b == '_' || b == '~'
+}
@@ -1,11 +1,16 @@
 package gosmarty
 
 import (
-	"sort"
+	"cmp"
+	"html"
+	"iter"
+	"math/big"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/szks-repo/gosmarty/ast"
-	"github.com/szks-repo/gosmarty/modifier"
+	"github.com/szks-repo/gosmarty/escape"
 	"github.com/szks-repo/gosmarty/object"
 )
 
@@ -14,134 +19,307 @@ var (
 )
 
 // Eval はASTノードを評価する中心的な関数
+// env は読み取り専用として扱われ、評価中に変更されることはありません。
 func Eval(node ast.Node, env *Environment) object.Object {
+	return eval(node, newScope(env, nil))
+}
+
+func eval(node ast.Node, sc *scope) object.Object {
 	switch node := node.(type) {
 	// ノードのリスト
 	case *ast.ListNode:
-		return evalNodes(node.Nodes, env)
+		return evalNodes(node.Nodes, sc)
 	// アクション {$...}
 	case *ast.ActionNode:
 		// ActionNodeの中の式を評価する
-		return Eval(node.Pipe, env)
+		return evalActionNode(node, sc)
 	// テキスト
 	case *ast.TextNode:
 		return object.NewString(node.Value)
 	// 識別子 (変数)
 	case *ast.Identifier:
-		return evalIdentifier(node, env)
+		return evalIdentifier(node, sc)
 	case *ast.FieldAccess:
-		return evalFieldAccess(node, env)
+		return evalFieldAccess(node, sc)
+	case *ast.LoopProperty:
+		if obj, ok := sc.loopProperty(node.Item.Value, node.Property.Value); ok {
+			return obj
+		}
+		return sc.undefined(node.Token.Pos(), "undefined loop property %s", node)
 	case *ast.IndexExpression:
-		return evalIndexExpression(node, env)
+		return evalIndexExpression(node, sc)
 	case *ast.NumberLiteral:
-		return &object.Number{Value: node.Value}
+		return evalNumberLiteral(node)
+	case *ast.StringLiteral:
+		return object.NewString(node.Value)
 	case *ast.InfixExpression:
-		return evalInfixExpression(node, env)
+		return evalInfixExpression(node, sc)
 	case *ast.IfNode:
-		return evalIfNode(node, env)
+		return evalIfNode(node, sc)
 	case *ast.PipeNode:
-		return evalPipeNode(node, env)
+		return evalPipeNode(node, sc)
 	case *ast.ForeachNode:
-		return evalForeachNode(node, env)
+		return evalForeachNode(node, sc)
+	case *ast.MethodCall:
+		return evalMethodCall(node, sc)
+	case *ast.CallExpression:
+		return evalCallExpression(node, sc)
+	case *ast.BooleanLiteral:
+		return boolObject(node.Value)
+	case *ast.NullLiteral:
+		return NULL
+	case *ast.InterpolatedString:
+		return evalInterpolatedString(node, sc)
+	case *ast.ArrayLiteral:
+		return evalArrayLiteral(node, sc)
+	case *ast.AssignNode:
+		return evalAssignNode(node, sc)
 	}
 
 	return nil
 }
 
 // evalNodes はノードのスライスを評価し、結果を連結する
-func evalNodes(nodes []ast.Node, env *Environment) object.Object {
-	var result string
+func evalNodes(nodes []ast.Node, sc *scope) object.Object {
+	var result strings.Builder
 	for _, node := range nodes {
-		evaluated := Eval(node, env)
-		// 評価結果がNULLでなければ、文字列として連結する
-	L:
-		if evaluated != nil {
-			if evaluated.Type() == object.OptionalType {
-				evaluated = evaluated.(*object.Optional).Unwrap()
-				goto L
-			}
-			if evaluated.Type() != object.NullType {
-				result += evaluated.Inspect()
-			}
+		evaluated := eval(node, sc)
+		if isError(evaluated) {
+			return evaluated
 		}
+		appendRendered(&result, evaluated)
+	}
+	return object.NewString(result.String())
+}
+
+// evalActionNode は式を評価し、エンジンの設定に応じて出力をエスケープする
+func evalActionNode(node *ast.ActionNode, sc *scope) object.Object {
+	result := eval(node.Pipe, sc)
+	if isError(result) {
+		return result
+	}
+	result = render(result)
+	if node.NoFilter {
+		return result
+	}
+	if len(node.Escapers) > 0 {
+		return object.NewHTML(escape.Apply(node.Escapers, result))
+	}
+	if !sc.engine.escapesHTML() {
+		return result
+	}
+	return escapeHTML(result)
+}
+
+// escapeHTML は安全な文字列 (object.HTML) 以外の出力をHTMLエスケープする
+func escapeHTML(obj object.Object) object.Object {
+	obj = unwrapOptional(obj)
+	if obj == nil || obj.Type() == object.NullType {
+		return obj
+	}
+	if obj.Type() == object.HTMLType {
+		return obj
 	}
-	return object.NewString(result)
+	return object.NewHTML(html.EscapeString(obj.Inspect()))
 }
 
 // evalIdentifier は環境から変数の値を探して返す
-func evalIdentifier(node *ast.Identifier, env *Environment) object.Object {
-	if val, ok := env.GetVar(node.Value); ok {
+func evalIdentifier(node *ast.Identifier, sc *scope) object.Object {
+	if val, ok := sc.GetVar(node.Value); ok {
 		return val
 	}
 
-	return NULL
+	return sc.undefined(node.Token.Pos(), "undefined variable $%s", node.Value)
 }
 
-func evalFieldAccess(node *ast.FieldAccess, env *Environment) object.Object {
+func evalFieldAccess(node *ast.FieldAccess, sc *scope) object.Object {
+	// $smarty.now のような予約変数
+	if ident, ok := node.Left.(*ast.Identifier); ok && ident.Value == "smarty" {
+		if val, ok := evalSmartyVariable(node.Right.Value, sc); ok {
+			return val
+		}
+	}
+
 	// 1. 左辺を評価する (e.g., $user -> MapObject)
-	left := Eval(node.Left, env)
+	left := unwrapOptional(eval(node.Left, sc))
+	if isError(left) {
+		return left
+	}
+	// NULL や値のない Optional のフィールドは、エラーにせず NULL を返す (null-safe)
+	if left == nil || left.Type() == object.NullType {
+		return NULL
+	}
+
+	// 時刻のサブフィールドは date_format と同じくエンジンのタイムゾーンで求める
+	if t, ok := left.(*object.Time); ok {
+		left = object.NewTime(sc.engine.localTime(t.Value))
+	}
+	// 時刻や期間のサブフィールド (e.g., $t.year, $d.hours) や独自の型のフィールド
+	if obj, ok := left.(object.FieldAccessor); ok {
+		if val, ok := obj.Field(node.Right.Value); ok {
+			return val
+		}
+		return sc.undefined(node.Right.Token.Pos(), "undefined field %s in %s", node.Right.Value, node.Left)
+	}
 
 	// 2. 左辺がMapでなければエラー (NULLを返す)
-	if left.Type() != object.MapType {
-		return NULL
+	objMap, ok := left.(object.MapLike)
+	if !ok {
+		return sc.undefined(node.Right.Token.Pos(), "cannot access field %s of %s value %s", node.Right.Value, left.Type(), node.Left)
 	}
 
 	// 3. Mapからプロパティを取得する
-	objMap := left.(*object.Map)
 	propName := node.Right.Value // (e.g., "id")
 
 	// 4. プロパティが存在すればその値を、なければNULLを返す
-	if val, ok := objMap.Value[propName]; ok {
+	if val, ok := objMap.Get(propName); ok {
 		return val
 	}
 
-	return NULL
+	return sc.undefined(node.Right.Token.Pos(), "undefined key %s in %s", propName, node.Left)
 }
 
-func evalInfixExpression(node *ast.InfixExpression, env *Environment) object.Object {
+func evalInfixExpression(node *ast.InfixExpression, sc *scope) object.Object {
 	switch node.Operator {
 	case "and":
-		left := unwrapOptional(Eval(node.Left, env))
+		left := unwrapOptional(eval(node.Left, sc))
 		if left == nil {
 			left = NULL
 		}
-		if !isTruthy(left) {
+		if isError(left) {
+			return left
+		}
+		if !sc.truthy(left) {
 			return object.FALSE
 		}
-		right := unwrapOptional(Eval(node.Right, env))
+		right := unwrapOptional(eval(node.Right, sc))
 		if right == nil {
 			right = NULL
 		}
-		if isTruthy(right) {
+		if isError(right) {
+			return right
+		}
+		if sc.truthy(right) {
 			return object.TRUE
 		}
 		return object.FALSE
 	case "or":
-		left := unwrapOptional(Eval(node.Left, env))
+		left := unwrapOptional(eval(node.Left, sc))
 		if left == nil {
 			left = NULL
 		}
-		if isTruthy(left) {
+		if isError(left) {
+			return left
+		}
+		if sc.truthy(left) {
 			return object.TRUE
 		}
-		right := unwrapOptional(Eval(node.Right, env))
+		right := unwrapOptional(eval(node.Right, sc))
 		if right == nil {
 			right = NULL
 		}
-		if isTruthy(right) {
+		if isError(right) {
+			return right
+		}
+		if sc.truthy(right) {
 			return object.TRUE
 		}
 		return object.FALSE
-	case ">", ">=", "<", "<=", "==", "!=":
-		left := Eval(node.Left, env)
-		right := Eval(node.Right, env)
-		return evalComparisonExpression(node.Operator, left, right)
+	case ">", ">=", "<", "<=", "==", "!=", "===", "!==":
+		left := eval(node.Left, sc)
+		if isError(left) {
+			return left
+		}
+		right := eval(node.Right, sc)
+		if isError(right) {
+			return right
+		}
+		return evalComparisonExpression(node.Operator, left, right, sc.engine.phpComparison())
+	case "+", "-":
+		left := eval(node.Left, sc)
+		if isError(left) {
+			return left
+		}
+		right := eval(node.Right, sc)
+		if isError(right) {
+			return right
+		}
+		return evalArithmeticExpression(node.Operator, left, right)
 	default:
 		return NULL
 	}
 }
 
-func evalComparisonExpression(op string, leftObj, rightObj object.Object) object.Object {
+// evalNumberLiteral は小数点のない数値リテラルを Integer に、小数を Decimal に評価する
+func evalNumberLiteral(node *ast.NumberLiteral) object.Object {
+	lit := node.Token.Literal
+	if !strings.Contains(lit, ".") {
+		if i, ok := new(big.Int).SetString(lit, 10); ok {
+			return &object.Integer{Value: i}
+		}
+	}
+	if d, ok := object.ParseDecimal(lit); ok {
+		return d
+	}
+	return &object.Number{Value: node.Value}
+}
+
+// evalArithmeticExpression は数値、時刻、期間の加減算を評価する
+//
+//	数値 ± 数値         -> object.AddNumbers を参照
+//	Time ± Duration     -> Time
+//	Duration + Time     -> Time
+//	Time - Time         -> Duration
+//	Duration ± Duration -> Duration
+func evalArithmeticExpression(op string, leftObj, rightObj object.Object) object.Object {
+	left := unwrapOptional(leftObj)
+	right := unwrapOptional(rightObj)
+	sign := 1
+	if op == "-" {
+		sign = -1
+	}
+
+	if object.IsNumeric(left) {
+		var result object.Object
+		var ok bool
+		if op == "-" {
+			result, ok = object.SubNumbers(left, right)
+		} else {
+			result, ok = object.AddNumbers(left, right)
+		}
+		if ok {
+			return result
+		}
+		return NULL
+	}
+
+	switch l := left.(type) {
+	case *object.Time:
+		switch r := right.(type) {
+		case *object.Duration:
+			return object.NewTime(l.Value.Add(time.Duration(sign) * r.Value))
+		case *object.Time:
+			if op == "-" {
+				return object.NewDuration(l.Value.Sub(r.Value))
+			}
+		}
+	case *object.Duration:
+		switch r := right.(type) {
+		case *object.Duration:
+			return object.NewDuration(l.Value + time.Duration(sign)*r.Value)
+		case *object.Time:
+			if op == "+" {
+				return object.NewTime(r.Value.Add(l.Value))
+			}
+		}
+	}
+
+	return NULL
+}
+
+// evalComparisonExpression は比較演算子を評価する
+// php が true の場合は PHP 8 の緩やかな比較の規則を使う
+func evalComparisonExpression(op string, leftObj, rightObj object.Object, php bool) object.Object {
 	left := unwrapOptional(leftObj)
 	if left == nil {
 		left = NULL
@@ -153,34 +331,81 @@ func evalComparisonExpression(op string, leftObj, rightObj object.Object) object
 
 	switch op {
 	case ">", ">=", "<", "<=":
-		lNum, lOk := left.(*object.Number)
-		rNum, rOk := right.(*object.Number)
-		if !lOk || !rOk {
+		compare := compareObjects
+		if php {
+			compare = looseCompare
+		}
+		order, ok := compare(left, right)
+		if !ok {
 			return object.FALSE
 		}
 		var result bool
 		switch op {
 		case ">":
-			result = lNum.Value > rNum.Value
+			result = order > 0
 		case ">=":
-			result = lNum.Value >= rNum.Value
+			result = order >= 0
 		case "<":
-			result = lNum.Value < rNum.Value
+			result = order < 0
 		case "<=":
-			result = lNum.Value <= rNum.Value
+			result = order <= 0
 		}
 		return boolObject(result)
 	case "==", "!=":
-		result := objectsEqual(left, right)
+		equal := objectsEqual
+		if php {
+			equal = looseEqual
+		}
+		result := equal(left, right)
 		if op == "!=" {
 			result = !result
 		}
 		return boolObject(result)
+	case "===", "!==":
+		result := identical(left, right)
+		if op == "!==" {
+			result = !result
+		}
+		return boolObject(result)
 	default:
 		return NULL
 	}
 }
 
+// compareObjects は順序付け可能な同種のオブジェクトを比較し、-1, 0, 1 のいずれかを返す
+func compareObjects(left, right object.Object) (int, bool) {
+	if l, ok := left.(object.Comparable); ok {
+		return l.Compare(right)
+	}
+	if r, ok := right.(object.Comparable); ok {
+		order, ok := r.Compare(left)
+		return -order, ok
+	}
+
+	if object.IsNumeric(left) {
+		return object.CompareNumbers(left, right)
+	}
+
+	if l, ok := stringValue(left); ok {
+		if r, ok := stringValue(right); ok {
+			return strings.Compare(l, r), true
+		}
+		return 0, false
+	}
+
+	switch l := left.(type) {
+	case *object.Time:
+		if r, ok := right.(*object.Time); ok {
+			return l.Value.Compare(r.Value), true
+		}
+	case *object.Duration:
+		if r, ok := right.(*object.Duration); ok {
+			return cmp.Compare(l.Value, r.Value), true
+		}
+	}
+	return 0, false
+}
+
 func objectsEqual(left, right object.Object) bool {
 	if left == nil && right == nil {
 		return true
@@ -189,6 +414,21 @@ func objectsEqual(left, right object.Object) bool {
 		return false
 	}
 
+	if _, ok := left.(object.Comparable); ok {
+		order, ok := compareObjects(left, right)
+		return ok && order == 0
+	}
+	if _, ok := right.(object.Comparable); ok {
+		order, ok := compareObjects(left, right)
+		return ok && order == 0
+	}
+
+	// Integer, Decimal, Number は値が等しければ型が異なっても等しい
+	if object.IsNumeric(left) && object.IsNumeric(right) {
+		order, _ := object.CompareNumbers(left, right)
+		return order == 0
+	}
+
 	if left.Type() != right.Type() {
 		return false
 	}
@@ -196,15 +436,18 @@ func objectsEqual(left, right object.Object) bool {
 	switch l := left.(type) {
 	case *object.Null:
 		return true
-	case *object.Number:
-		r := right.(*object.Number)
-		return l.Value == r.Value
 	case *object.String:
 		r := right.(*object.String)
 		return l.Value == r.Value
 	case *object.Boolean:
 		r := right.(*object.Boolean)
 		return l.Value == r.Value
+	case *object.Time:
+		r := right.(*object.Time)
+		return l.Value.Equal(r.Value)
+	case *object.Duration:
+		r := right.(*object.Duration)
+		return l.Value == r.Value
 	default:
 		return left == right
 	}
@@ -217,22 +460,28 @@ func boolObject(val bool) object.Object {
 	return object.FALSE
 }
 
-func evalIfNode(in *ast.IfNode, env *Environment) object.Object {
-	condition := Eval(in.Condition, env)
+func evalIfNode(in *ast.IfNode, sc *scope) object.Object {
+	condition := eval(in.Condition, sc)
+	if isError(condition) {
+		return condition
+	}
 
-	if isTruthy(condition) {
-		return Eval(in.Consequence, env)
+	if sc.truthy(condition) {
+		return eval(in.Consequence, sc)
 	}
 
 	for _, elseifNode := range in.ElseIfs {
-		elseifCondition := Eval(elseifNode.Condition, env)
-		if isTruthy(elseifCondition) {
-			return Eval(elseifNode.Consequence, env)
+		elseifCondition := eval(elseifNode.Condition, sc)
+		if isError(elseifCondition) {
+			return elseifCondition
+		}
+		if sc.truthy(elseifCondition) {
+			return eval(elseifNode.Consequence, sc)
 		}
 	}
 
 	if in.Alternative != nil {
-		return Eval(in.Alternative, env)
+		return eval(in.Alternative, sc)
 	}
 
 	return NULL
@@ -241,20 +490,27 @@ func evalIfNode(in *ast.IfNode, env *Environment) object.Object {
 // isTruthy はオブジェクトが「真」であるかを判定するヘルパー
 func isTruthy(obj object.Object) bool {
 	switch obj := obj.(type) {
+	case object.Truthy:
+		return obj.Truthy()
 	case *object.Null:
 		return false
 	case *object.String:
 		return obj.Value != ""
+	case *object.HTML:
+		return obj.Value != ""
 	case *object.Boolean:
 		return obj.Value
-	case *object.Number:
-		return obj.Value != 0
-	case *object.Map:
-		return len(obj.Value) > 0
-	case *object.Array:
-		return len(obj.Value) > 0
+	case *object.Number, *object.Integer, *object.Decimal:
+		sign, _ := object.Sign(obj)
+		return sign != 0
+	case object.MapLike:
+		return obj.Len() > 0
+	case object.ArrayLike:
+		return obj.Len() > 0
 	case *object.Time:
 		return !obj.Value.IsZero()
+	case *object.Duration:
+		return obj.Value != 0
 	case *object.Optional:
 		if obj.Some() {
 			return isTruthy(obj.Unwrap())
@@ -265,121 +521,268 @@ func isTruthy(obj object.Object) bool {
 	}
 }
 
-func evalPipeNode(node *ast.PipeNode, env *Environment) object.Object {
-	// 1. 左辺を評価する
-	left := Eval(node.Left, env)
+// evalSmartyVariable は $smarty.now のような予約変数を評価する
+func evalSmartyVariable(name string, sc *scope) (object.Object, bool) {
+	switch name {
+	case "now":
+		return object.NewTime(sc.engine.currentTime()), true
+	case "foreach":
+		return sc.foreachLoops(), true
+	default:
+		return nil, false
+	}
+}
 
+func evalPipeNode(node *ast.PipeNode, sc *scope) object.Object {
 	funcName := node.Function.Value
-	fn, ok := modifier.Get(funcName)
+
+	// 1. 左辺を評価する
+	// {$var|default:"..."} の左辺は、未定義でも報告しない
+	var left object.Object
+	if funcName == "default" {
+		left = unwrapOptional(sc.quietly(node.Left))
+	} else {
+		left = unwrapOptional(eval(node.Left, sc))
+	}
+	if left == nil {
+		left = NULL
+	}
+	if isError(left) {
+		return left
+	}
+
+	fn, ok := sc.engine.modifier(funcName)
 	if !ok {
-		// エラー処理: 未定義の関数
-		// ここでは空文字を返す
+		return sc.undefined(node.Function.Token.Pos(), "unknown modifier %s", funcName)
+	}
+
+	// 2. 引数を評価する
+	args, errObj := evalArguments(node.Args, sc)
+	if errObj != nil {
+		return errObj
+	}
+
+	apply := func(input object.Object) object.Object {
+		result, err := fn(sc.renderContext(), input, args...)
+		if err != nil {
+			return object.NewError("%s: modifier %s: %s", node.Function.Token.Pos(), funcName, err)
+		}
+		if result == nil {
+			return NULL
+		}
+		return result
+	}
+	if node.ApplyToArray {
+		return apply(left)
+	}
+
+	// '@' が付いていない修飾子は、配列の要素ごとに適用する (Smarty 2 と同じ)
+	switch left := left.(type) {
+	case object.ArrayLike:
+		results := make([]object.Object, left.Len())
+		for i := range results {
+			elem, _ := left.At(i)
+			results[i] = apply(elem)
+			if isError(results[i]) {
+				return results[i]
+			}
+		}
+		return &object.Array{Value: results}
+	case object.MapLike:
+		// 結果はマップのキーの順序を保った OrderedMap になる
+		results := object.NewOrderedMap()
+		for _, key := range object.OrderedKeys(left) {
+			elem, ok := left.Get(key)
+			if !ok {
+				continue
+			}
+			result := apply(elem)
+			if isError(result) {
+				return result
+			}
+			results.Set(key, result)
+		}
+		return results
+	}
+	return apply(left)
+}
+
+func evalIndexExpression(node *ast.IndexExpression, sc *scope) object.Object {
+	left := unwrapOptional(eval(node.Left, sc))
+	if isError(left) {
+		return left
+	}
+	index := unwrapOptional(eval(node.Index, sc))
+	if isError(index) {
+		return index
+	}
+	// NULL や値のない Optional の要素は、エラーにせず NULL を返す (null-safe)
+	if left == nil || left.Type() == object.NullType {
 		return NULL
 	}
 
-	return fn(left)
+	switch obj := left.(type) {
+	case object.Indexable:
+		if elem, ok := obj.Index(index); ok {
+			return elem
+		}
+	case object.ArrayLike:
+		// 負のインデックスは末尾から数える
+		idx, ok := indexInt(index)
+		if ok && idx < 0 {
+			idx += obj.Len()
+		}
+		if elem, found := obj.At(idx); ok && found {
+			return elem
+		}
+	case object.MapLike:
+		if elem, ok := obj.Get(mapKeyString(index)); ok {
+			return elem
+		}
+	default:
+		return sc.undefined(node.Token.Pos(), "cannot index %s value %s", left.Type(), node.Left)
+	}
+
+	return sc.undefined(node.Token.Pos(), "undefined index %s in %s", index.Inspect(), node.Left)
 }
 
-func evalIndexExpression(node *ast.IndexExpression, env *Environment) object.Object {
-	left := Eval(node.Left, env)
-	index := Eval(node.Index, env)
+// evalInterpolatedString は文字列に埋め込まれた式を評価して連結する
+// 埋め込まれた値はここではエスケープせず、出力するときに文字列全体としてエスケープする
+func evalInterpolatedString(node *ast.InterpolatedString, sc *scope) object.Object {
+	var out strings.Builder
+	for _, part := range node.Parts {
+		obj := eval(part, sc)
+		if isError(obj) {
+			return obj
+		}
+		appendRendered(&out, obj)
+	}
+	return object.NewString(out.String())
+}
 
-	// ArrayをNumberでインデックスアクセスする場合のみを考慮
-	if left.Type() == object.ArrayType && index.Type() == object.NumberType {
-		arrObject := left.(*object.Array)
-		idx := int(index.(*object.Number).Value)
+// evalArrayLiteral は配列リテラルを評価する
+// キーを指定した要素がなければ Array を、あれば PHP の配列と同じ規則の OrderedMap を返す
+func evalArrayLiteral(node *ast.ArrayLiteral, sc *scope) object.Object {
+	if !node.HasKeys() {
+		values := make([]object.Object, len(node.Values))
+		for i, val := range node.Values {
+			obj := eval(val, sc)
+			if isError(obj) {
+				return obj
+			}
+			values[i] = obj
+		}
+		return &object.Array{Value: values}
+	}
 
-		// 範囲外アクセスチェック
-		if idx < 0 || idx >= len(arrObject.Value) {
-			return NULL // 範囲外ならNULLを返す
+	m := object.NewOrderedMap()
+	for i, val := range node.Values {
+		obj := eval(val, sc)
+		if isError(obj) {
+			return obj
+		}
+		if node.Keys[i] == nil {
+			m.Append(obj)
+			continue
+		}
+		key := unwrapOptional(eval(node.Keys[i], sc))
+		if isError(key) {
+			return key
 		}
-		return arrObject.Value[idx]
+		m.Set(mapKeyString(key), obj)
 	}
+	return m
+}
 
+// evalAssignNode は {assign} の値を評価して変数に代入する
+func evalAssignNode(node *ast.AssignNode, sc *scope) object.Object {
+	val := eval(node.Value, sc)
+	if isError(val) {
+		return val
+	}
+	sc.SetVar(node.Name, val)
 	return NULL
 }
 
-func evalForeachNode(node *ast.ForeachNode, env *Environment) object.Object {
-	iterable := unwrapOptional(Eval(node.Source, env))
+// indexInt は配列のインデックスに使う整数を返す
+// PHP と同じく、"1" のような整数の文字列も受け付ける
+func indexInt(index object.Object) (int, bool) {
+	if s, ok := index.(*object.String); ok {
+		if key, ok := object.MapKey(s.Value).(*object.Integer); ok {
+			return object.ToInt(key)
+		}
+		return 0, false
+	}
+	return object.ToInt(index)
+}
+
+// mapKeyString はマップのキーに使う文字列を返す
+// PHP と同じく、整数として表せる数値と真偽値は整数のキーに、NULL は空文字列のキーになる (e.g., 1.0 -> "1")
+func mapKeyString(index object.Object) string {
+	switch index := index.(type) {
+	case *object.Null:
+		return ""
+	case *object.Boolean:
+		if index.Value {
+			return "1"
+		}
+		return "0"
+	}
+	if i, ok := object.ToInt(index); ok {
+		return strconv.Itoa(i)
+	}
+	return index.Inspect()
+}
+
+func evalForeachNode(node *ast.ForeachNode, sc *scope) object.Object {
+	iterable := unwrapOptional(eval(node.Source, sc))
 	if iterable == nil {
 		iterable = NULL
 	}
-
-	prevItem, hadPrevItem := env.GetVar(node.Item)
-	var prevKey object.Object
-	var hadPrevKey bool
-	if node.Key != "" {
-		prevKey, hadPrevKey = env.GetVar(node.Key)
+	if isError(iterable) {
+		return iterable
 	}
 
-	var foreachMap *object.Map
-	var loopState *object.Map
-	var prevLoopState object.Object
-	var hadPrevLoop bool
+	// ループ変数は共有の Environment ではなく、このループのフレームに書き込む
+	f := sc.push()
+	defer sc.pop()
+
+	state := &loopState{}
+	f.item = node.Item
+	f.loop = state
 	if node.Name != "" {
-		foreachMap = ensureSmartyForeachMap(env)
-		prevLoopState, hadPrevLoop = foreachMap.Value[node.Name]
-		loopState = &object.Map{Value: map[string]object.Object{}}
-		foreachMap.Value[node.Name] = loopState
-	}
-
-	defer func() {
-		if node.Item != "" {
-			if hadPrevItem {
-				env.setVar(node.Item, prevItem)
-			} else {
-				env.unsetVar(node.Item)
-			}
-		}
-		if node.Key != "" {
-			if hadPrevKey {
-				env.setVar(node.Key, prevKey)
-			} else {
-				env.unsetVar(node.Key)
-			}
-		}
-		if node.Name != "" && foreachMap != nil {
-			if hadPrevLoop {
-				foreachMap.Value[node.Name] = prevLoopState
-			} else {
-				delete(foreachMap.Value, node.Name)
-			}
-		}
-	}()
+		f.loops[node.Name] = state
+	}
 
 	var rendered strings.Builder
 	iterated := false
-
-	switch obj := iterable.(type) {
-	case *object.Array:
-		total := len(obj.Value)
-		for idx, elem := range obj.Value {
-			iterated = true
-			env.setVar(node.Item, elem)
-			if node.Key != "" {
-				env.setVar(node.Key, &object.Number{Value: float64(idx)})
-			}
-			updateForeachLoopState(loopState, idx, total)
-			appendRendered(&rendered, Eval(node.Body, env))
-		}
-	case *object.Map:
-		if len(obj.Value) > 0 {
-			keys := make([]string, 0, len(obj.Value))
-			for key := range obj.Value {
-				keys = append(keys, key)
-			}
-			sort.Strings(keys)
-			total := len(keys)
-			for idx, key := range keys {
-				iterated = true
-				env.setVar(node.Item, obj.Value[key])
-				if node.Key != "" {
-					env.setVar(node.Key, object.NewString(key))
-				}
-				updateForeachLoopState(loopState, idx, total)
-				appendRendered(&rendered, Eval(node.Body, env))
+	var errObj object.Object
+
+	items, total := foreachItems(iterable, sc)
+	eachWithLast(items, total, func(idx int, key, elem object.Object, last func() bool) bool {
+		iterated = true
+		// 変換できなかった要素
+		for _, v := range []object.Object{key, elem} {
+			if isError(v) {
+				errObj = v
+				return false
 			}
 		}
+		f.vars[node.Item] = elem
+		if node.Key != "" {
+			f.vars[node.Key] = key
+		}
+		*state = loopState{idx: idx, total: total, last: last}
+		body := eval(node.Body, sc)
+		if isError(body) {
+			errObj = body
+			return false
+		}
+		appendRendered(&rendered, body)
+		return true
+	})
+	if errObj != nil {
+		return errObj
 	}
 
 	if iterated {
@@ -390,23 +793,114 @@ func evalForeachNode(node *ast.ForeachNode, env *Environment) object.Object {
 	}
 
 	if node.Alternative != nil {
-		return Eval(node.Alternative, env)
+		return eval(node.Alternative, sc)
 	}
 
 	return NULL
 }
 
-func appendRendered(b *strings.Builder, obj object.Object) {
+// foreachItems は {foreach} で走査するキーと値の組と、要素の数を返す
+// 要素の数が走査するまで分からない場合は -1 を返す
+func foreachItems(iterable object.Object, sc *scope) (iter.Seq2[object.Object, object.Object], int) {
+	switch obj := iterable.(type) {
+	case object.Iterable:
+		if n, ok := obj.(interface{ Len() int }); ok {
+			return obj.All(), n.Len()
+		}
+		return obj.All(), -1
+	case object.ArrayLike:
+		return func(yield func(object.Object, object.Object) bool) {
+			for idx := range obj.Len() {
+				elem, _ := obj.At(idx)
+				if !yield(object.NewInteger(idx), elem) {
+					return
+				}
+			}
+		}, obj.Len()
+	case object.MapLike:
+		keys := sc.engine.mapKeys(obj)
+		_, ordered := obj.(*object.OrderedMap)
+		return func(yield func(object.Object, object.Object) bool) {
+			for _, key := range keys {
+				elem, _ := obj.Get(key)
+				// PHP の配列と同じく、OrderedMap の整数のキーは Integer として渡す
+				var keyObj object.Object = object.NewString(key)
+				if ordered {
+					keyObj = object.MapKey(key)
+				}
+				if !yield(keyObj, elem) {
+					return
+				}
+			}
+		}, len(keys)
+	default:
+		return func(func(object.Object, object.Object) bool) {}, 0
+	}
+}
+
+// eachWithLast は items を走査し、各要素が最後の要素かどうかを判定する関数を fn に渡す
+// 要素の数が分からない場合、判定する関数は呼び出されたときに次の要素を1つ先に読み出す
+// 呼び出されなければ、fn が走査を止めた後の要素は読み出さない
+func eachWithLast(items iter.Seq2[object.Object, object.Object], total int, fn func(idx int, key, elem object.Object, last func() bool) bool) {
+	if total >= 0 {
+		idx := 0
+		for key, elem := range items {
+			i := idx
+			if !fn(idx, key, elem, func() bool { return i == total-1 }) {
+				return
+			}
+			idx++
+		}
+		return
+	}
+
+	next, stop := iter.Pull2(items)
+	defer stop()
+	key, elem, ok := next()
+	for idx := 0; ok; idx++ {
+		var nextKey, nextElem object.Object
+		peeked, hasNext := false, false
+		peek := func() {
+			if !peeked {
+				nextKey, nextElem, hasNext = next()
+				peeked = true
+			}
+		}
+		if !fn(idx, key, elem, func() bool { peek(); return !hasNext }) {
+			return
+		}
+		peek()
+		key, elem, ok = nextKey, nextElem, hasNext
+	}
+}
+
+// render は出力する値を返す
+// Optional は中身を取り出し、object.Renderer を実装した値は Render の結果を使う
+func render(obj object.Object) object.Object {
 	obj = unwrapOptional(obj)
+	if r, ok := obj.(object.Renderer); ok {
+		obj = unwrapOptional(r.Render())
+	}
 	if obj == nil {
-		return
+		return NULL
 	}
+	return obj
+}
+
+// appendRendered は値を render と同じ規則で文字列にして b に追加する
+// NULL は何も出力しない
+func appendRendered(b *strings.Builder, obj object.Object) {
+	obj = render(obj)
 	if obj.Type() == object.NullType {
 		return
 	}
 	b.WriteString(obj.Inspect())
 }
 
+func isError(obj object.Object) bool {
+	return obj != nil && obj.Type() == object.ErrorType
+}
+
 func unwrapOptional(obj object.Object) object.Object {
 	for obj != nil && obj.Type() == object.OptionalType {
 		opt := obj.(*object.Optional)
@@ -418,36 +912,53 @@ func unwrapOptional(obj object.Object) object.Object {
 	return obj
 }
 
-func ensureSmartyForeachMap(env *Environment) *object.Map {
-	var smartyMap *object.Map
-	if current, ok := env.GetVar("smarty"); ok {
-		if existing, ok := current.(*object.Map); ok {
-			smartyMap = existing
-		}
-	}
-	if smartyMap == nil {
-		smartyMap = &object.Map{Value: map[string]object.Object{}}
-		env.setVar("smarty", smartyMap)
+// loopState は $smarty.foreach.<name> と {$item@...} で参照するループの状態
+// last は参照されたときに初めて判定する。要素の数が分からないループでは、このとき次の要素を1つ先に読み出す
+type loopState struct {
+	idx   int
+	total int // 走査するまで分からない場合は -1 で、total は NULL になる
+	last  func() bool
+}
+
+var loopStateKeys = []string{"index", "iteration", "first", "last", "total"}
+
+func (s *loopState) Type() object.ObjectType {
+	return object.MapType
+}
+
+func (s *loopState) Inspect() string {
+	pairs := make([]string, len(loopStateKeys))
+	for i, key := range loopStateKeys {
+		v, _ := s.Get(key)
+		pairs[i] = key + ":" + v.Inspect()
 	}
+	return "{" + strings.Join(pairs, ", ") + "}"
+}
 
-	var foreachMap *object.Map
-	if existing, ok := smartyMap.Value["foreach"]; ok {
-		if casted, ok := existing.(*object.Map); ok {
-			foreachMap = casted
+func (s *loopState) Get(key string) (object.Object, bool) {
+	switch key {
+	case "index":
+		return object.NewInteger(s.idx), true
+	case "iteration":
+		return object.NewInteger(s.idx + 1), true
+	case "first":
+		return object.NewBool(s.idx == 0), true
+	case "last":
+		return object.NewBool(s.last()), true
+	case "total":
+		if s.total < 0 {
+			return NULL, true
 		}
+		return object.NewInteger(s.total), true
+	default:
+		return nil, false
 	}
-	if foreachMap == nil {
-		foreachMap = &object.Map{Value: map[string]object.Object{}}
-		smartyMap.Value["foreach"] = foreachMap
-	}
+}
 
-	return foreachMap
+func (s *loopState) Keys() []string {
+	return loopStateKeys
 }
 
-func updateForeachLoopState(loopState *object.Map, idx, total int) {
-	if loopState == nil || total <= 0 {
-		return
-	}
-	loopState.Value["first"] = object.NewBool(idx == 0)
-	loopState.Value["last"] = object.NewBool(idx == total-1)
+func (s *loopState) Len() int {
+	return len(loopStateKeys)
 }
@@ -0,0 +1,133 @@
+package gosmarty
+
+import (
+	"github.com/szks-repo/gosmarty/ast"
+	"github.com/szks-repo/gosmarty/modifier"
+	"github.com/szks-repo/gosmarty/object"
+)
+
+// builtinFunction はテンプレートから呼び出せる組み込みの関数です。
+// isset() のように評価する前の式が必要な関数があるため、引数は式のまま渡されます。
+type builtinFunction func(node *ast.CallExpression, sc *scope) object.Object
+
+// Builtin functions
+// Funcs で同じ名前の関数を登録した場合は、登録した関数が優先されます。
+// - isset(値, ...): すべての値が存在し、NULL でなければ true
+// - empty(値): 値が存在しないか、偽であれば true
+// - is_array(値), is_string(値), is_numeric(値), is_null(値)
+// - count(値): 配列やマップの要素の数 ({$items|@count} と同じ)
+func lookupBuiltinFunction(name string) (builtinFunction, bool) {
+	switch name {
+	case "isset":
+		return builtinIsset, true
+	case "empty":
+		return builtinEmpty, true
+	case "is_array":
+		return unaryFunction(func(v object.Object, _ *scope) object.Object {
+			switch v.(type) {
+			case object.ArrayLike, object.MapLike:
+				return object.TRUE
+			default:
+				return object.FALSE
+			}
+		}), true
+	case "is_string":
+		return unaryFunction(func(v object.Object, _ *scope) object.Object {
+			_, ok := stringValue(v)
+			return boolObject(ok)
+		}), true
+	case "is_numeric":
+		return unaryFunction(func(v object.Object, _ *scope) object.Object {
+			if s, ok := stringValue(v); ok {
+				_, ok := numericString(s)
+				return boolObject(ok)
+			}
+			return boolObject(object.IsNumeric(v))
+		}), true
+	case "is_null":
+		return unaryFunction(func(v object.Object, _ *scope) object.Object {
+			return boolObject(v.Type() == object.NullType)
+		}), true
+	case "count":
+		return unaryFunction(func(v object.Object, sc *scope) object.Object {
+			count, _ := modifier.Builtins().GetContext("count")
+			result, err := count(sc.renderContext(), v)
+			if err != nil {
+				return object.NewError("count: %s", err)
+			}
+			return result
+		}), true
+	default:
+		return nil, false
+	}
+}
+
+// builtinFunctionArity は組み込みの関数が受け取る引数の数です。
+var builtinFunctionArity = map[string]modifier.Arity{
+	"isset":      {Min: 1, Max: -1},
+	"empty":      {Min: 1, Max: 1},
+	"is_array":   {Min: 1, Max: 1},
+	"is_string":  {Min: 1, Max: 1},
+	"is_numeric": {Min: 1, Max: 1},
+	"is_null":    {Min: 1, Max: 1},
+	"count":      {Min: 1, Max: 1},
+}
+
+// isset は PHP の isset と同じく、すべての引数が存在して NULL でなければ true を返す
+// $a.b.c の途中が存在しない場合もエラーにはならない
+func builtinIsset(node *ast.CallExpression, sc *scope) object.Object {
+	if len(node.Args) == 0 {
+		return object.NewError("%s: isset: want at least 1 argument, got 0", node.Function.Token.Pos())
+	}
+	for _, arg := range node.Args {
+		if !isSet(arg, sc) {
+			return object.FALSE
+		}
+	}
+	return object.TRUE
+}
+
+// empty は PHP の empty と同じく、引数が存在しないか偽であれば true を返す
+func builtinEmpty(node *ast.CallExpression, sc *scope) object.Object {
+	if len(node.Args) != 1 {
+		return object.NewError("%s: empty: want 1 argument, got %d", node.Function.Token.Pos(), len(node.Args))
+	}
+	v, ok := setValue(node.Args[0], sc)
+	if !ok {
+		return object.TRUE
+	}
+	return boolObject(!sc.truthy(v))
+}
+
+// isSet は式の値が存在し、NULL でないかを返す
+func isSet(node ast.Node, sc *scope) bool {
+	_, ok := setValue(node, sc)
+	return ok
+}
+
+// setValue は式を1度だけ評価し、値が存在して NULL でなければその値を返す
+// 評価に失敗した場合も存在しないものとして扱う
+func setValue(node ast.Node, sc *scope) (object.Object, bool) {
+	v := unwrapOptional(sc.quietly(node))
+	if v == nil || isError(v) || v.Type() == object.NullType {
+		return nil, false
+	}
+	return v, true
+}
+
+// unaryFunction は引数を1つ受け取る関数を builtinFunction にする
+func unaryFunction(fn func(v object.Object, sc *scope) object.Object) builtinFunction {
+	return func(node *ast.CallExpression, sc *scope) object.Object {
+		if len(node.Args) != 1 {
+			return object.NewError("%s: %s: want 1 argument, got %d", node.Function.Token.Pos(), node.Function.Value, len(node.Args))
+		}
+		v := unwrapOptional(eval(node.Args[0], sc))
+		if v == nil {
+			v = NULL
+		}
+		if isError(v) {
+			return v
+		}
+		return fn(v, sc)
+	}
+}
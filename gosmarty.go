@@ -1,10 +1,16 @@
 package gosmarty
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"reflect"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/szks-repo/gosmarty/ast"
+	"github.com/szks-repo/gosmarty/escape"
 	"github.com/szks-repo/gosmarty/lexer"
 	"github.com/szks-repo/gosmarty/modifier"
 	"github.com/szks-repo/gosmarty/object"
@@ -13,12 +19,186 @@ import (
 
 type GoSmarty struct {
 	templates map[string]*Template
+	location  *time.Location
+	locale    string
+	charset   string
+	now       func() time.Time
+	// escape_html: すべての出力を既定でHTMLエスケープする
+	escapeHTML bool
+	// html/template と同様に、HTMLの文脈に応じてエスケープする
+	contextualEscape bool
+	// テンプレートから呼び出せるメソッド。nil の場合はその型のすべての公開メソッドを許可する
+	allowedMethods map[reflect.Type][]string
+	// エンジンごとの修飾子。組み込みと RegisterModifier で登録された修飾子を引き継ぐ
+	modifiers *modifier.Registry
+	// Funcs で登録された、テンプレートから呼び出せる関数
+	funcs map[string]reflect.Value
+	// {foreach} でマップを走査する順序
+	mapOrder MapOrder
+	// ==, <, > などの比較の規則
+	comparison Comparison
+	// 未定義の変数や修飾子の扱い
+	errorLevel ErrorLevel
+	// Parse で修飾子と関数が登録されているかを検証する
+	validation bool
 }
 
-func New() *GoSmarty {
-	return &GoSmarty{
+// MapOrder は {foreach} でマップを走査する順序です。
+type MapOrder int
+
+const (
+	// MapOrderInsertion はマップが持つ順序で走査します (既定)。
+	// OrderedMap は挿入順、構造体はフィールドの宣言順で、順序を持たないGoのマップはキーの辞書順になります。
+	MapOrderInsertion MapOrder = iota
+	// MapOrderSorted はすべてのマップをキーの辞書順で走査します。
+	MapOrderSorted
+)
+
+// Comparison は ==, !=, <, > などで型の異なる値を比較するときの規則です。
+// === と !== はどちらの規則でも型と値が等しい場合だけ真になります。
+type Comparison int
+
+const (
+	// ComparisonTyped は数値どうしを除き、型の異なる値を等しくないものとして扱います (既定)。
+	ComparisonTyped Comparison = iota
+	// ComparisonPHP は PHP 8 の緩やかな比較と同じ規則で比較します。
+	// 数値形式の文字列は数値として比較し、真偽値や NULL との比較は真偽値に変換して比較します。
+	// {if} などの真偽の判定でも、PHP と同じく "0" を偽として扱います。
+	ComparisonPHP
+)
+
+// ErrorLevel は未定義の変数、キー、修飾子を参照したときの扱いです。
+//
+// NULL や値のない Optional のフィールド ({$missing.a.b} の .a.b)、isset() と empty() の引数、
+// {$var|default:"..."} の左辺は、どのレベルでも報告されません。
+type ErrorLevel int
+
+const (
+	// ErrorLevelSilent は未定義のものを NULL として扱い、何も報告しません (既定)。
+	ErrorLevelSilent ErrorLevel = iota
+	// ErrorLevelWarn は未定義のものを NULL として扱い、Template.ExecuteWithWarnings が返す警告に記録します。
+	ErrorLevelWarn
+	// ErrorLevelStrict は未定義のものを参照した時点で、位置を含むエラーを返して実行を中止します。
+	ErrorLevelStrict
+)
+
+// Option は GoSmarty エンジンの設定を変更します。
+type Option func(gsm *GoSmarty)
+
+// WithTimezone は日付の整形に使うタイムゾーンを指定します。
+// 指定しない場合は time.Local が使われます。
+func WithTimezone(loc *time.Location) Option {
+	return func(gsm *GoSmarty) {
+		if loc != nil {
+			gsm.location = loc
+		}
+	}
+}
+
+// WithLocale は月名や曜日名に使うロケールを指定します (e.g., "en", "ja_JP")。
+func WithLocale(locale string) Option {
+	return func(gsm *GoSmarty) {
+		gsm.locale = locale
+	}
+}
+
+// WithCharset は出力の文字コードを指定します。
+// 指定しない場合は modifier.DefaultCharset (UTF-8) が使われます。
+// 値は RenderContext を通して修飾子に渡されます。
+func WithCharset(charset string) Option {
+	return func(gsm *GoSmarty) {
+		gsm.charset = charset
+	}
+}
+
+// WithMapOrder は {foreach} でマップを走査する順序を指定します。
+func WithMapOrder(order MapOrder) Option {
+	return func(gsm *GoSmarty) {
+		gsm.mapOrder = order
+	}
+}
+
+// WithComparison は ==, !=, <, > などの比較の規則を指定します。
+// PHP の Smarty から移行したテンプレートには ComparisonPHP を指定してください。
+func WithComparison(c Comparison) Option {
+	return func(gsm *GoSmarty) {
+		gsm.comparison = c
+	}
+}
+
+// WithErrorLevel は未定義の変数、キー、修飾子を参照したときの扱いを指定します。
+func WithErrorLevel(level ErrorLevel) Option {
+	return func(gsm *GoSmarty) {
+		gsm.errorLevel = level
+	}
+}
+
+// WithValidation は Parse の時点で、テンプレートで使われている修飾子と関数がエンジンに登録されているかを検証します。
+// 引数の数を宣言している修飾子 (組み込みの修飾子、RegisterModifierFunc で登録した修飾子) と関数は、引数の数も検証します。
+// 修飾子と関数は Parse を呼び出す前に登録してください。
+func WithValidation(enabled bool) Option {
+	return func(gsm *GoSmarty) {
+		gsm.validation = enabled
+	}
+}
+
+// WithEscapeHTML は Smarty の escape_html に相当し、すべての {$var} の出力を既定でHTMLエスケープします。
+// {$var nofilter} や {$var|raw}、object.HTML を返す修飾子の出力はエスケープされません。
+func WithEscapeHTML(enabled bool) Option {
+	return func(gsm *GoSmarty) {
+		gsm.escapeHTML = enabled
+	}
+}
+
+// WithContextualEscaping は html/template と同様に、テキストのHTML構造を解析して
+// 各 {$var} の出力先 (要素の本文、属性値、URL、<script>、<style>、イベントハンドラ) に応じたエスケープを適用します。
+// 文脈を決定できないテンプレートは Parse がエラーを返します。
+func WithContextualEscaping(enabled bool) Option {
+	return func(gsm *GoSmarty) {
+		gsm.contextualEscape = enabled
+	}
+}
+
+// WithAllowedMethods はテンプレートから {$obj->method()} で呼び出せるメソッドを型ごとに許可します。
+// sample にはその型の値かポインタを渡します (e.g., (*User)(nil))。
+// names を省略した場合は、その型のすべての公開メソッドを許可します。
+// 許可されていないメソッドの呼び出しはエラーになります。
+func WithAllowedMethods(sample any, names ...string) Option {
+	return func(gsm *GoSmarty) {
+		typ := reflect.TypeOf(sample)
+		for typ != nil && typ.Kind() == reflect.Ptr {
+			typ = typ.Elem()
+		}
+		if typ == nil {
+			return
+		}
+		if gsm.allowedMethods == nil {
+			gsm.allowedMethods = make(map[reflect.Type][]string)
+		}
+		if len(names) == 0 {
+			gsm.allowedMethods[typ] = nil
+			return
+		}
+		if allowed, ok := gsm.allowedMethods[typ]; ok && allowed == nil {
+			return
+		}
+		gsm.allowedMethods[typ] = append(gsm.allowedMethods[typ], names...)
+	}
+}
+
+func New(opts ...Option) *GoSmarty {
+	gsm := &GoSmarty{
 		templates: make(map[string]*Template, 0),
+		location:  time.Local,
+		charset:   modifier.DefaultCharset,
+		now:       time.Now,
 	}
+	for _, opt := range opts {
+		opt(gsm)
+	}
+	gsm.modifiers = modifier.NewRegistry(modifier.Default())
+
+	return gsm
 }
 
 func (gsm *GoSmarty) Parse(input string) (*Template, error) {
@@ -27,29 +207,175 @@ func (gsm *GoSmarty) Parse(input string) (*Template, error) {
 	if errs := p.Errors(); len(errs) > 0 {
 		return nil, errors.New(strings.Join(errs, "\n"))
 	}
+	if gsm.validation {
+		if errs := gsm.validate(tree); len(errs) > 0 {
+			return nil, errors.New(strings.Join(errs, "\n"))
+		}
+	}
+	if gsm.contextualEscape {
+		if err := escape.Tree(tree); err != nil {
+			return nil, err
+		}
+	}
 
 	return &Template{
+		gsm:  gsm,
 		tree: tree,
 	}, nil
 }
 
+// RegisterModifier はプロセス内のすべてのエンジンに修飾子を登録します。
+//
+// Deprecated: エンジンごとに修飾子を登録する (*GoSmarty).RegisterModifier を使用してください。
 func RegisterModifier(name string, mod modifier.Modifier) {
 	modifier.Register(name, mod)
 }
 
+// RegisterModifier はこのエンジンにだけ修飾子を登録します。
+// 同じ名前の組み込みの修飾子は、このエンジンの中でのみ上書きされます。
+func (gsm *GoSmarty) RegisterModifier(name string, mod modifier.Modifier) {
+	gsm.modifiers.Register(name, mod)
+}
+
+// RegisterContextModifier は実行中の RenderContext を参照でき、エラーを返せる修飾子をこのエンジンに登録します。
+func (gsm *GoSmarty) RegisterContextModifier(name string, mod modifier.ContextModifier) {
+	gsm.modifiers.RegisterContext(name, mod)
+}
+
+// RegisterModifierFunc は通常のGoの関数を修飾子としてこのエンジンに登録します。
+// 入力と引数の変換、省略された引数の既定値については modifier.Func を参照してください。
+func (gsm *GoSmarty) RegisterModifierFunc(name string, fn any, defaults ...any) error {
+	if err := gsm.modifiers.RegisterFunc(name, fn, defaults...); err != nil {
+		return fmt.Errorf("modifier %s: %w", name, err)
+	}
+	return nil
+}
+
+// Funcs はテンプレートから {name(arg, ...)} の形で呼び出せるGoの関数を登録します。
+// text/template の Funcs と同様に、値は関数である必要があり、戻り値は1つか、2つ目が error である必要があります。
+// 引数は object.Object から関数の引数の型に変換され、error が nil でない場合は評価がエラーになります。
+// テンプレートの実行前に呼び出してください。条件を満たさない値を渡すと panic します。
+func (gsm *GoSmarty) Funcs(funcMap map[string]any) *GoSmarty {
+	if gsm.funcs == nil {
+		gsm.funcs = make(map[string]reflect.Value, len(funcMap))
+	}
+	for name, fn := range funcMap {
+		v := reflect.ValueOf(fn)
+		if err := checkFunc(v); err != nil {
+			panic(fmt.Sprintf("gosmarty: func %q: %s", name, err))
+		}
+		gsm.funcs[name] = v
+	}
+	return gsm
+}
+
+// function は Funcs で登録された関数を探します。
+func (gsm *GoSmarty) function(name string) (reflect.Value, bool) {
+	if gsm == nil {
+		return reflect.Value{}, false
+	}
+	fn, ok := gsm.funcs[name]
+	return fn, ok
+}
+
+// modifier はエンジンの Registry から修飾子を探します。
+func (gsm *GoSmarty) modifier(name string) (modifier.ContextModifier, bool) {
+	if gsm == nil {
+		return modifier.Default().GetContext(name)
+	}
+	return gsm.modifiers.GetContext(name)
+}
+
+// currentTime はエンジンのタイムゾーンでの現在時刻 ($smarty.now) を返します。
+func (gsm *GoSmarty) currentTime() time.Time {
+	if gsm == nil {
+		return time.Now()
+	}
+	return gsm.now().In(gsm.location)
+}
+
+// localTime は t をエンジンのタイムゾーンでの時刻に変換します。
+func (gsm *GoSmarty) localTime(t time.Time) time.Time {
+	if gsm == nil {
+		return t.In(time.Local)
+	}
+	return t.In(gsm.location)
+}
+
+func (gsm *GoSmarty) methodAllowed(typ reflect.Type, name string) bool {
+	if gsm == nil {
+		return false
+	}
+	allowed, ok := gsm.allowedMethods[typ]
+	if !ok {
+		return false
+	}
+	return allowed == nil || slices.Contains(allowed, name)
+}
+
+// mapKeys はエンジンの MapOrder に従って m のキーを返します。
+func (gsm *GoSmarty) mapKeys(m object.MapLike) []string {
+	if gsm != nil && gsm.mapOrder == MapOrderSorted {
+		keys := slices.Clone(m.Keys())
+		slices.Sort(keys)
+		return keys
+	}
+	return object.OrderedKeys(m)
+}
+
+func (gsm *GoSmarty) phpComparison() bool {
+	return gsm != nil && gsm.comparison == ComparisonPHP
+}
+
+func (gsm *GoSmarty) level() ErrorLevel {
+	if gsm == nil {
+		return ErrorLevelSilent
+	}
+	return gsm.errorLevel
+}
+
+func (gsm *GoSmarty) escapesHTML() bool {
+	return gsm != nil && gsm.escapeHTML
+}
+
 func (gsm *GoSmarty) ExecuteTemplate(name string, env *Environment) object.Object {
 	t, ok := gsm.templates[name]
 	if !ok {
 		panic("ERR: TODO")
 	}
 
-	return Eval(t.tree.Root, env)
+	return t.Execute(env)
 }
 
 type Template struct {
+	gsm  *GoSmarty
 	tree *ast.Tree
 }
 
 func (t *Template) Execute(env *Environment) object.Object {
-	return Eval(t.tree.Root, env)
+	return t.ExecuteContext(context.Background(), env)
+}
+
+// ExecuteContext は ctx を RenderContext として修飾子に渡してテンプレートを実行します。
+func (t *Template) ExecuteContext(ctx context.Context, env *Environment) object.Object {
+	result, _ := t.ExecuteWithWarnings(ctx, env)
+	return result
+}
+
+// ExecuteWithWarnings は ExecuteContext と同じくテンプレートを実行し、
+// ErrorLevelWarn のエンジンで見つかった未定義の変数などの警告を、出力とあわせて返します。
+func (t *Template) ExecuteWithWarnings(ctx context.Context, env *Environment) (object.Object, []Warning) {
+	sc := newScope(env, t.gsm)
+	sc.ctx = ctx
+	return eval(t.tree.Root, sc), sc.warnings
+}
+
+// Warning は ErrorLevelWarn のエンジンで、実行中に見つかった問題です。
+type Warning struct {
+	Pos     string // "行:列"
+	Message string
+}
+
+func (w Warning) String() string {
+	return w.Pos + ": " + w.Message
 }
@@ -1,7 +1,17 @@
 package gosmarty
 
 import (
+	"cmp"
+	"context"
 	"fmt"
+	"html"
+	"iter"
+	"maps"
+	"math/big"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -169,7 +179,7 @@ func TestModifier(t *testing.T) {
 		t.Run(fmt.Sprintf("case-%d", i+1), func(t *testing.T) {
 			gsm := New()
 			for modName, mod := range tt.modifiers {
-				RegisterModifier(modName, mod)
+				gsm.RegisterModifier(modName, mod)
 			}
 
 			tmpl, err := gsm.Parse(tt.input)
@@ -533,3 +543,2102 @@ func TestIfStatements(t *testing.T) {
 		}
 	}
 }
+
+func TestDateFormat(t *testing.T) {
+	t.Parallel()
+
+	jst := time.FixedZone("JST", 9*60*60)
+	tests := []struct {
+		name  string
+		input string
+		opts  []Option
+		env   *Environment
+		want  string
+	}{
+		{
+			name:  "default format",
+			input: `{$t|date_format}`,
+			opts:  []Option{WithTimezone(time.UTC)},
+			env: Must(NewEnvironment(
+				WithVariable("t", time.Date(2024, 1, 3, 15, 4, 6, 0, time.UTC)),
+			)),
+			want: "Jan  3, 2024",
+		},
+		{
+			name:  "custom format",
+			input: `{$t|date_format:"%Y/%m/%d %H:%M:%S %A %p"}`,
+			opts:  []Option{WithTimezone(time.UTC)},
+			env: Must(NewEnvironment(
+				WithVariable("t", time.Date(2024, 1, 3, 15, 4, 6, 0, time.UTC)),
+			)),
+			want: "2024/01/03 15:04:06 Wednesday PM",
+		},
+		{
+			name:  "timezone conversion",
+			input: `{$t|date_format:"%F %T %Z"}`,
+			opts:  []Option{WithTimezone(jst)},
+			env: Must(NewEnvironment(
+				WithVariable("t", time.Date(2024, 1, 3, 15, 4, 6, 0, time.UTC)),
+			)),
+			want: "2024-01-04 00:04:06 JST",
+		},
+		{
+			name:  "unix timestamp",
+			input: `{$ts|date_format:"%Y-%m-%d %H:%M"}`,
+			opts:  []Option{WithTimezone(time.UTC)},
+			env: Must(NewEnvironment(
+				WithVariable("ts", 1704294246),
+			)),
+			want: "2024-01-03 15:04",
+		},
+		{
+			name:  "parseable string",
+			input: `{$s|date_format:"%d %B %Y"}`,
+			opts:  []Option{WithTimezone(time.UTC)},
+			env: Must(NewEnvironment(
+				WithVariable("s", "2024-03-09 08:00:00"),
+			)),
+			want: "09 March 2024",
+		},
+		{
+			name:  "default date",
+			input: `[{$empty|date_format:"%Y-%m-%d":"2020-02-29"}][{$empty|date_format}]`,
+			opts:  []Option{WithTimezone(time.UTC)},
+			env: Must(NewEnvironment(
+				WithVariable("empty", ""),
+			)),
+			want: "[2020-02-29][]",
+		},
+		{
+			name:  "locale",
+			input: `{$t|date_format:"%Y年%B%e日(%a) %p%l時"}`,
+			opts:  []Option{WithTimezone(jst), WithLocale("ja_JP.UTF-8")},
+			env: Must(NewEnvironment(
+				WithVariable("t", time.Date(2024, 1, 3, 15, 4, 6, 0, jst)),
+			)),
+			want: "2024年1月 3日(水) 午後 3時",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gsm := New(tt.opts...)
+			tmpl, err := gsm.Parse(tt.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			evaled := tmpl.Execute(tt.env)
+			result, ok := evaled.(*object.String)
+			if !ok {
+				t.Fatal("isn't object.String")
+			}
+
+			if result.Value != tt.want {
+				t.Errorf("result has wrong value. got=%q, want=%q", result.Value, tt.want)
+			}
+		})
+	}
+}
+
+func TestTimeOperations(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		input string
+		env   *Environment
+		want  string
+	}{
+		{
+			name:  "compare with $smarty.now",
+			input: `{if $order.shippedAt < $smarty.now}shipped{else}pending{/if}`,
+			env: Must(NewEnvironment(
+				WithVariable("order", map[string]any{
+					"shippedAt": time.Date(2024, 5, 9, 0, 0, 0, 0, time.UTC),
+				}),
+			)),
+			want: "shipped",
+		},
+		{
+			name:  "compare times",
+			input: `{if $a >= $b}yes{else}no{/if}{if $a == $c}same{/if}`,
+			env: Must(NewEnvironment(
+				WithVariable("a", time.Date(2024, 5, 9, 0, 0, 0, 0, time.UTC)),
+				WithVariable("b", time.Date(2024, 5, 10, 0, 0, 0, 0, time.UTC)),
+				WithVariable("c", time.Date(2024, 5, 9, 9, 0, 0, 0, time.FixedZone("JST", 9*60*60))),
+			)),
+			want: "nosame",
+		},
+		{
+			name:  "subfields",
+			input: `{$t.year}-{$t.month}-{$t.day} {$t.hour}:{$t.minute}:{$t.second} w{$t.weekday} d{$t.yearday}`,
+			env: Must(NewEnvironment(
+				WithVariable("t", time.Date(2024, 2, 3, 4, 5, 6, 0, time.UTC)),
+			)),
+			want: "2024-2-3 4:5:6 w6 d34",
+		},
+		{
+			// サブフィールドは date_format と同じくエンジンのタイムゾーン (UTC) で求める
+			name:  "subfields in engine timezone",
+			input: `{$t.year}-{$t.month}-{$t.day} {$t.hour} w{$t.weekday}|{$t|date_format:"%Y-%m-%d %H %w"}`,
+			env: Must(NewEnvironment(
+				WithVariable("t", time.Date(2024, 1, 1, 5, 0, 0, 0, time.FixedZone("JST", 9*60*60))),
+			)),
+			want: "2023-12-31 20 w0|2023-12-31 20 0",
+		},
+		{
+			name:  "integer subfields",
+			input: `{$t.unix} {$t.unix + 1} {$d.seconds} {$d.hours} {$d.days}`,
+			env: Must(NewEnvironment(
+				WithVariable("t", time.Date(2024, 10, 19, 1, 2, 3, 0, time.UTC)),
+				WithVariable("d", 416*time.Hour+40*time.Minute),
+			)),
+			want: "1729299723 1729299724 1500000 416.6666666666667 17.36111111111111",
+		},
+		{
+			name:  "duration arithmetic",
+			input: `{if $order.shippedAt + $sla < $smarty.now}late{else}on time{/if} {$smarty.now - $order.shippedAt} {($smarty.now - $order.shippedAt).hours}`,
+			env: Must(NewEnvironment(
+				WithVariable("order", map[string]any{
+					"shippedAt": time.Date(2024, 5, 8, 12, 0, 0, 0, time.UTC),
+				}),
+				WithVariable("sla", 24*time.Hour),
+			)),
+			want: "late 48h0m0s 48",
+		},
+		{
+			name:  "time minus duration",
+			input: `{$t - $d|date_format:"%F %T"}`,
+			env: Must(NewEnvironment(
+				WithVariable("t", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)),
+				WithVariable("d", 90*time.Minute),
+			)),
+			want: "2023-12-31 22:30:00",
+		},
+		{
+			name:  "number arithmetic",
+			input: `{$a + $b - 1}`,
+			env: Must(NewEnvironment(
+				WithVariable("a", 10),
+				WithVariable("b", 5),
+			)),
+			want: "14",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gsm := New(WithTimezone(time.UTC))
+			gsm.now = func() time.Time { return now }
+			tmpl, err := gsm.Parse(tt.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			evaled := tmpl.Execute(tt.env)
+			result, ok := evaled.(*object.String)
+			if !ok {
+				t.Fatal("isn't object.String")
+			}
+
+			if result.Value != tt.want {
+				t.Errorf("result has wrong value. got=%q, want=%q", result.Value, tt.want)
+			}
+		})
+	}
+}
+
+func TestEscapeHTML(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		input  string
+		escape bool
+		env    *Environment
+		want   string
+	}{
+		{
+			name:   "escaped by default",
+			input:  `<p>{$comment}</p>`,
+			escape: true,
+			env: Must(NewEnvironment(
+				WithVariable("comment", `<script>alert("x")</script> & 'y'`),
+			)),
+			want: `<p>&lt;script&gt;alert(&#34;x&#34;)&lt;/script&gt; &amp; &#39;y&#39;</p>`,
+		},
+		{
+			name:   "escape disabled",
+			input:  `<p>{$comment}</p>`,
+			escape: false,
+			env: Must(NewEnvironment(
+				WithVariable("comment", `<b>bold</b>`),
+			)),
+			want: `<p><b>bold</b></p>`,
+		},
+		{
+			name:   "nofilter",
+			input:  `{$banner nofilter}|{$banner|upper nofilter}`,
+			escape: true,
+			env: Must(NewEnvironment(
+				WithVariable("banner", `<b>sale</b>`),
+			)),
+			want: `<b>sale</b>|<B>SALE</B>`,
+		},
+		{
+			name:   "raw modifier",
+			input:  `{$banner|raw}`,
+			escape: true,
+			env: Must(NewEnvironment(
+				WithVariable("banner", Ptr(`<b>sale</b>`)),
+			)),
+			want: `<b>sale</b>`,
+		},
+		{
+			name:   "nl2br is not double escaped",
+			input:  `{$body|nl2br}`,
+			escape: true,
+			env: Must(NewEnvironment(
+				WithVariable("body", "a < b\nc & d"),
+			)),
+			want: "a &lt; b<br />c &amp; d",
+		},
+		{
+			name:   "nl2br keeps the input when escape is disabled",
+			input:  `{$body|nl2br}`,
+			escape: false,
+			env: Must(NewEnvironment(
+				WithVariable("body", "a<b>\nc"),
+			)),
+			want: "a<b><br />c",
+		},
+		{
+			name:   "escape modifier is not double escaped",
+			input:  `{$q|escape}|{$q|escape:"url"}`,
+			escape: true,
+			env: Must(NewEnvironment(
+				WithVariable("q", `a&b c`),
+			)),
+			want: "a&amp;b c|a%26b+c",
+		},
+		{
+			name:   "non string values",
+			input:  `{$num}{$missing}`,
+			escape: true,
+			env: Must(NewEnvironment(
+				WithVariable("num", 42),
+			)),
+			want: "42",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gsm := New(WithEscapeHTML(tt.escape))
+			tmpl, err := gsm.Parse(tt.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			evaled := tmpl.Execute(tt.env)
+			result, ok := evaled.(*object.String)
+			if !ok {
+				t.Fatal("isn't object.String")
+			}
+
+			if result.Value != tt.want {
+				t.Errorf("result has wrong value. got=%q, want=%q", result.Value, tt.want)
+			}
+		})
+	}
+}
+
+func TestContextualEscaping(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		input   string
+		env     *Environment
+		want    string
+		wantErr bool
+	}{
+		{
+			name:  "element body",
+			input: `<p>{$v}</p>`,
+			env:   Must(NewEnvironment(WithVariable("v", `<b>"x"</b>`))),
+			want:  `<p>&lt;b&gt;&#34;x&#34;&lt;/b&gt;</p>`,
+		},
+		{
+			name:  "quoted attribute",
+			input: `<input value="{$v}" title='{$v}'>`,
+			env:   Must(NewEnvironment(WithVariable("v", `a"b'c`))),
+			want:  `<input value="a&#34;b&#39;c" title='a&#34;b&#39;c'>`,
+		},
+		{
+			name:  "unquoted attribute",
+			input: `<input value={$v}>`,
+			env:   Must(NewEnvironment(WithVariable("v", `a b>c`))),
+			want:  `<input value=a&#32;b&#62;c>`,
+		},
+		{
+			name:  "url attribute",
+			input: `<a href="{$url}">x</a><a href="/search?q={$q}&amp;p=1">y</a>`,
+			env: Must(NewEnvironment(
+				WithVariable("url", `javascript:alert(1)`),
+				WithVariable("q", `a&b c`),
+			)),
+			want: `<a href="#ZgotmplZ">x</a><a href="/search?q=a%26b%20c&amp;p=1">y</a>`,
+		},
+		{
+			name:  "url path",
+			input: `<img src="/img/{$file}">`,
+			env:   Must(NewEnvironment(WithVariable("file", `a b".png`))),
+			want:  `<img src="/img/a%20b%22.png">`,
+		},
+		{
+			name:  "script value and string",
+			input: `<script>var user = {$name}; var msg = '{$name}';</script>{$name}`,
+			env:   Must(NewEnvironment(WithVariable("name", `</script><b>'`))),
+			want:  `<script>var user = "\u003c/script\u003e\u003cb\u003e\u0027"; var msg = '\u003c/script\u003e\u003cb\u003e\u0027';</script>&lt;/script&gt;&lt;b&gt;&#39;`,
+		},
+		{
+			name:  "style",
+			input: `<p style="color: {$color}">x</p><p style="color: {$bad}; font-family: '{$font}'">y</p>`,
+			env: Must(NewEnvironment(
+				WithVariable("color", "#ff0000"),
+				WithVariable("bad", "expression(alert(1))"),
+				WithVariable("font", "a';b"),
+			)),
+			want: `<p style="color: #ff0000">x</p><p style="color: ZgotmplZ; font-family: 'a\27 \3b b'">y</p>`,
+		},
+		{
+			name:  "event handler attribute",
+			input: `<button onclick="track('{$id}', {$n})">x</button>`,
+			env: Must(NewEnvironment(
+				WithVariable("id", `a');alert('x`),
+				WithVariable("n", 3),
+			)),
+			want: `<button onclick="track('a\u0027);alert(\u0027x', 3)">x</button>`,
+		},
+		{
+			name:  "safe html only in element body",
+			input: `<div title="{$text|nl2br}">{$text|nl2br}</div>`,
+			env:   Must(NewEnvironment(WithVariable("text", "a\nb"))),
+			want:  `<div title="a&lt;br /&gt;b">a<br />b</div>`,
+		},
+		{
+			name:  "nofilter",
+			input: `<div>{$v nofilter}</div>`,
+			env:   Must(NewEnvironment(WithVariable("v", `<b>x</b>`))),
+			want:  `<div><b>x</b></div>`,
+		},
+		{
+			name:  "conditional attribute",
+			input: `<input type="checkbox" {if $checked}checked{/if} name="{$name}">`,
+			env: Must(NewEnvironment(
+				WithVariable("checked", true),
+				WithVariable("name", `a"b`),
+			)),
+			want: `<input type="checkbox" checked name="a&#34;b">`,
+		},
+		{
+			name:  "foreach",
+			input: `<ul>{foreach from=$items item=item}<li data-id="{$item}">{$item}</li>{/foreach}</ul>`,
+			env:   Must(NewEnvironment(WithVariable("items", []string{"<a>", `"b"`}))),
+			want:  `<ul><li data-id="&lt;a&gt;">&lt;a&gt;</li><li data-id="&#34;b&#34;">&#34;b&#34;</li></ul>`,
+		},
+		{
+			name:    "branches end in different contexts",
+			input:   `{if $x}<a href="{else}<b>{/if}x`,
+			wantErr: true,
+		},
+		{
+			name:    "foreach body changes context",
+			input:   `{foreach from=$items item=item}<a title="{/foreach}`,
+			wantErr: true,
+		},
+		{
+			name:    "action in template literal",
+			input:   "<script>var s = `{$x}`;</script>",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gsm := New(WithContextualEscaping(true))
+			tmpl, err := gsm.Parse(tt.input)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("wantErr=true, but err is nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			evaled := tmpl.Execute(tt.env)
+			result, ok := evaled.(*object.String)
+			if !ok {
+				t.Fatal("isn't object.String")
+			}
+
+			if result.Value != tt.want {
+				t.Errorf("result has wrong value.\ngot =%q\nwant=%q", result.Value, tt.want)
+			}
+		})
+	}
+}
+
+func TestConcurrentExecute(t *testing.T) {
+	t.Parallel()
+
+	// サイト全体で共有する Environment
+	env := Must(NewEnvironment(
+		WithVariable("siteName", "gosmarty"),
+		WithVariable("items", []string{"a", "b", "c"}),
+		WithVariable("item", "shared"),
+	))
+	gsm := New()
+	tmpl := Must(gsm.Parse(`{$siteName}:{foreach from=$items item=item key=i name=list}{if $smarty.foreach.list.first}[{/if}{$i}={$item}{if $smarty.foreach.list.last}]{/if}{/foreach}:{$item}`))
+	want := "gosmarty:[0=a1=b2=c]:shared"
+
+	var wg sync.WaitGroup
+	errs := make(chan string, 100)
+	for range 100 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, ok := tmpl.Execute(env).(*object.String)
+			if !ok || result.Value != want {
+				errs <- fmt.Sprintf("got=%v, want=%q", result, want)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+	if v, _ := env.GetVar("item"); v.Inspect() != "shared" {
+		t.Errorf("shared environment was modified: item=%q", v.Inspect())
+	}
+}
+
+func TestModifierRegistryPerEngine(t *testing.T) {
+	t.Parallel()
+
+	admin := New()
+	storefront := New()
+	admin.RegisterModifier("badge", func(input object.Object, args ...any) object.Object {
+		return object.NewString("[admin:" + input.Inspect() + "]")
+	})
+	storefront.RegisterModifier("upper", func(input object.Object, args ...any) object.Object {
+		return object.NewString("UPPER(" + input.Inspect() + ")")
+	})
+	RegisterModifier("registry_test_global", func(input object.Object, args ...any) object.Object {
+		return object.NewString("global:" + input.Inspect())
+	})
+
+	env := Must(NewEnvironment(WithVariable("name", "smarty")))
+	tests := []struct {
+		gsm   *GoSmarty
+		input string
+		want  string
+	}{
+		{gsm: admin, input: `{$name|badge}`, want: "[admin:smarty]"},
+		{gsm: storefront, input: `{$name|badge}`, want: ""},
+		{gsm: admin, input: `{$name|upper}`, want: "SMARTY"},
+		{gsm: storefront, input: `{$name|upper}`, want: "UPPER(smarty)"},
+		{gsm: New(), input: `{$name|upper}`, want: "SMARTY"},
+		{gsm: admin, input: `{$name|registry_test_global}`, want: "global:smarty"},
+		{gsm: storefront, input: `{$name|registry_test_global}`, want: "global:smarty"},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("case-%d", i+1), func(t *testing.T) {
+			tmpl, err := tt.gsm.Parse(tt.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			result, ok := tmpl.Execute(env).(*object.String)
+			if !ok {
+				t.Fatal("isn't object.String")
+			}
+			if result.Value != tt.want {
+				t.Errorf("result has wrong value. got=%q, want=%q", result.Value, tt.want)
+			}
+		})
+	}
+}
+
+func TestDateFormatPrecedence(t *testing.T) {
+	t.Parallel()
+
+	tokyo := time.FixedZone("JST", 9*60*60)
+	env := Must(NewEnvironment(WithVariable("t", time.Date(2024, 1, 1, 20, 0, 0, 0, time.UTC))))
+
+	custom := New(WithTimezone(tokyo))
+	custom.RegisterModifier("date_format", func(input object.Object, args ...any) object.Object {
+		return object.NewString("custom")
+	})
+	tests := []struct {
+		gsm  *GoSmarty
+		want string
+	}{
+		// 組み込みの date_format は実行中のエンジンのタイムゾーンを使う
+		{gsm: New(WithTimezone(tokyo)), want: "2024-01-02 05"},
+		{gsm: New(WithTimezone(time.UTC)), want: "2024-01-01 20"},
+		// エンジンに登録した date_format は組み込みより優先される
+		{gsm: custom, want: "custom"},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("case-%d", i+1), func(t *testing.T) {
+			tmpl, err := tt.gsm.Parse(`{$t|date_format:"%F %H"}`)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := tmpl.Execute(env).Inspect(); got != tt.want {
+				t.Errorf("got=%q, want=%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewEnvironmentErrors(t *testing.T) {
+	t.Parallel()
+
+	t.Run("collect all errors", func(t *testing.T) {
+		env, err := NewEnvironment(
+			WithVariable("ok", "value"),
+			WithVariable("byScore", map[float64]string{1.5: "a"}),
+			WithVariable("callback", func() {}),
+		)
+		if err == nil {
+			t.Fatal("want error, but err is nil")
+		}
+		for _, want := range []string{`variable "byScore"`, `variable "callback"`} {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("error %q does not contain %q", err.Error(), want)
+			}
+		}
+		if env == nil {
+			t.Fatal("env should be returned in non-strict mode")
+		}
+		if _, ok := env.GetVar("ok"); !ok {
+			t.Error("valid variable should be kept")
+		}
+		if _, ok := env.GetVar("byScore"); ok {
+			t.Error("invalid variable should not be set")
+		}
+	})
+
+	t.Run("strict", func(t *testing.T) {
+		env, err := NewEnvironment(
+			WithStrict(),
+			WithVariable("ok", "value"),
+			WithVariable("byScore", map[float64]string{1.5: "a"}),
+		)
+		if err == nil {
+			t.Fatal("want error, but err is nil")
+		}
+		if env != nil {
+			t.Error("env should be nil in strict mode")
+		}
+	})
+
+	t.Run("no error", func(t *testing.T) {
+		env, err := NewEnvironment(WithStrict(), WithVariable("ok", "value"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := env.GetVar("ok"); !ok {
+			t.Error("variable should be set")
+		}
+	})
+}
+
+func TestLazyVariable(t *testing.T) {
+	t.Parallel()
+
+	type Image struct {
+		URL string `gosmarty:"url"`
+	}
+	type Product struct {
+		Name   string `gosmarty:"name"`
+		Price  int    `gosmarty:"price"`
+		Images []Image
+		Tags   map[string]bool
+		Parent *Product
+	}
+	type Meta struct {
+		Note string `json:"note"`
+	}
+	type Sparse struct {
+		*Meta
+		A string `json:"a,omitempty"`
+		B string `json:"b"`
+	}
+	type Node struct {
+		Name string `json:"name"`
+		Next *Node  `json:"next"`
+	}
+	cyclic := &Node{Name: "a"}
+	cyclic.Next = cyclic
+
+	env := Must(NewEnvironment(
+		WithLazyVariable("product", &Product{
+			Name:   "Mug",
+			Price:  1500,
+			Images: []Image{{URL: "/a.png"}, {URL: "/b.png"}},
+			Tags:   map[string]bool{"new": true, "sale": false},
+		}),
+		WithLazyVariable("sparse", Sparse{B: "b"}),
+		WithLazyVariable("cyclic", cyclic),
+	))
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{input: `{$product.name}:{$product.price|number_format}`, want: "Mug:1,500"},
+		{input: `{$product.Images[1].url}`, want: "/b.png"},
+		{input: `{foreach from=$product.Images item=img key=i}{$i}={$img.url};{/foreach}`, want: "0=/a.png;1=/b.png;"},
+		{input: `{foreach from=$product.Tags item=on key=tag}{$tag}:{$on};{/foreach}`, want: "new:true;sale:false;"},
+		{input: `{if $product.Images}has images{/if}{if $product.Parent}has parent{/if}`, want: "has images"},
+		// omitempty のゼロ値と nil の埋め込みポインタのフィールドは出力しない
+		{input: `{$sparse}`, want: "{b:b}"},
+		// 循環する値は繰り返し現れたところで打ち切る
+		{input: `{$cyclic}`, want: "{name:a, next:*RECURSION*}"},
+		{input: `{$cyclic.next.next.name}`, want: "a"},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("case-%d", i+1), func(t *testing.T) {
+			tmpl, err := New().Parse(tt.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			result, ok := tmpl.Execute(env).(*object.String)
+			if !ok {
+				t.Fatal("isn't object.String")
+			}
+			if result.Value != tt.want {
+				t.Errorf("result has wrong value. got=%q, want=%q", result.Value, tt.want)
+			}
+		})
+	}
+}
+
+type testUser struct {
+	FirstName string
+	LastName  string
+	Tags      []string
+}
+
+func (u testUser) GetFullName() string {
+	return u.FirstName + " " + u.LastName
+}
+
+func (u *testUser) HasTag(tag string) bool {
+	return slices.Contains(u.Tags, tag)
+}
+
+func (u testUser) Secret() string {
+	return "secret"
+}
+
+type testCart struct {
+	Amount float64
+}
+
+func (c *testCart) Total(currency string) (string, error) {
+	if currency != "JPY" {
+		return "", fmt.Errorf("unsupported currency %q", currency)
+	}
+	return fmt.Sprintf("¥%.0f", c.Amount), nil
+}
+
+func (c *testCart) PerPerson(people int) int {
+	return int(c.Amount) / people
+}
+
+func (c *testCart) Discounted(rate float64, codes ...string) *testCart {
+	_ = codes
+	return &testCart{Amount: c.Amount * (1 - rate)}
+}
+
+func TestMethodCall(t *testing.T) {
+	t.Parallel()
+
+	env := Must(NewEnvironment(
+		WithLazyVariable("user", testUser{FirstName: "Taro", LastName: "Yamada", Tags: []string{"vip"}}),
+		WithLazyVariable("cart", &testCart{Amount: 1200}),
+		WithVariable("plainUser", testUser{FirstName: "Taro"}),
+	))
+	gsm := New(
+		WithAllowedMethods(testUser{}, "GetFullName", "HasTag"),
+		WithAllowedMethods((*testCart)(nil)),
+	)
+
+	tests := []struct {
+		input   string
+		want    string
+		wantErr string
+	}{
+		{input: `{$user->getFullName()}`, want: "Taro Yamada"},
+		{input: `{$user->FirstName}`, want: "Taro"},
+		{input: `{if $user->hasTag("vip")}VIP{/if}{if $user->hasTag("new")}NEW{/if}`, want: "VIP"},
+		{input: `{$cart->total("JPY")}`, want: "¥1200"},
+		{input: `{$cart->discounted(0.5, "A", "B")->total("JPY")}`, want: "¥600"},
+		{input: `{$cart->total("USD")}`, wantErr: `1:9: gosmarty.testCart.Total: unsupported currency "USD"`},
+		{input: `{$cart->total(1, 2)}`, wantErr: "1:9: gosmarty.testCart.Total: want 1 arguments, got 2"},
+		{input: `{$cart->total($user)}`, wantErr: "argument 1"},
+		{input: `{$cart->perPerson(3)}`, want: "400"},
+		{input: `{$cart->perPerson(0)}`, wantErr: "1:9: gosmarty.testCart.PerPerson: panic: runtime error: integer divide by zero"},
+		{input: `{$user->secret()}`, wantErr: "1:9: method gosmarty.testUser.Secret is not allowed"},
+		{input: `{$user->missing()}`, wantErr: "is not allowed"},
+		{input: `{$user.Tags->count()}`, wantErr: "1:14: cannot call method count on array value"},
+		// WithVariable で設定した構造体はマップに変換されるため、メソッドを持たない
+		{input: `{$plainUser->getFullName()}`, wantErr: "1:14: cannot call method getFullName on map value (set structs with WithLazyVariable to call their methods)"},
+		{input: `{$nobody->getFullName()}`, want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			tmpl, err := gsm.Parse(tt.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			evaled := tmpl.Execute(env)
+			if tt.wantErr != "" {
+				errObj, ok := evaled.(*object.Error)
+				if !ok {
+					t.Fatalf("want *object.Error, got %#v", evaled)
+				}
+				if !strings.Contains(errObj.Message, tt.wantErr) {
+					t.Errorf("error %q does not contain %q", errObj.Message, tt.wantErr)
+				}
+				return
+			}
+
+			result, ok := evaled.(*object.String)
+			if !ok {
+				t.Fatalf("isn't object.String: %#v", evaled)
+			}
+			if result.Value != tt.want {
+				t.Errorf("result has wrong value. got=%q, want=%q", result.Value, tt.want)
+			}
+		})
+	}
+}
+
+func TestFuncs(t *testing.T) {
+	t.Parallel()
+
+	env := Must(NewEnvironment(
+		WithVariable("p", 1200),
+		WithVariable("stock", 3),
+		WithVariable("tags", []string{"new", "sale"}),
+	))
+	gsm := New().Funcs(map[string]any{
+		"format_price": func(price float64, currency string) string {
+			return fmt.Sprintf("%s %.0f", currency, price)
+		},
+		"in_stock": func(n int) bool { return n > 0 },
+		"parse_code": func(s string) (string, error) {
+			if s == "" {
+				return "", fmt.Errorf("empty code")
+			}
+			return strings.ToUpper(s), nil
+		},
+		"join": strings.Join,
+		"must_positive": func(n int) int {
+			if n <= 0 {
+				panic("not positive")
+			}
+			return n
+		},
+	})
+
+	tests := []struct {
+		input   string
+		want    string
+		wantErr string
+	}{
+		{input: `{format_price($p, "JPY")}`, want: "JPY 1200"},
+		{input: `{format_price($p + 300, "JPY")|upper}`, want: "JPY 1500"},
+		{input: `{if in_stock($stock)}in stock{else}sold out{/if}`, want: "in stock"},
+		{input: `{if in_stock($stock - 3)}in stock{else}sold out{/if}`, want: "sold out"},
+		{input: `{parse_code("abc")}`, want: "ABC"},
+		{input: `{join($tags, ", ")}`, want: "new, sale"},
+		{input: `{parse_code("")}`, wantErr: "parse_code: empty code"},
+		{input: `{format_price($p)}`, wantErr: "1:2: format_price: want 2 arguments, got 1"},
+		{input: `{format_price("a", "JPY")}`, wantErr: "format_price: argument 1"},
+		{input: `{missing_func(1)}`, wantErr: "1:2: function missing_func is not defined"},
+		{input: `{must_positive(0)}`, wantErr: "1:2: must_positive: panic: not positive"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			tmpl, err := gsm.Parse(tt.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			evaled := tmpl.Execute(env)
+			if tt.wantErr != "" {
+				errObj, ok := evaled.(*object.Error)
+				if !ok {
+					t.Fatalf("want *object.Error, got %#v", evaled)
+				}
+				if !strings.Contains(errObj.Message, tt.wantErr) {
+					t.Errorf("error %q does not contain %q", errObj.Message, tt.wantErr)
+				}
+				return
+			}
+
+			result, ok := evaled.(*object.String)
+			if !ok {
+				t.Fatalf("isn't object.String: %#v", evaled)
+			}
+			if result.Value != tt.want {
+				t.Errorf("result has wrong value. got=%q, want=%q", result.Value, tt.want)
+			}
+		})
+	}
+
+	t.Run("invalid func", func(t *testing.T) {
+		defer func() {
+			if recover() == nil {
+				t.Error("Funcs should panic for a non-function value")
+			}
+		}()
+		New().Funcs(map[string]any{"bad": 1})
+	})
+}
+
+func TestRegisterModifierFunc(t *testing.T) {
+	t.Parallel()
+
+	env := Must(NewEnvironment(
+		WithVariable("title", "Hello, gosmarty world"),
+		WithVariable("price", 1980),
+		WithVariable("tags", []string{"a", "b"}),
+	))
+	gsm := New()
+
+	truncate := func(s string, length int, etc string) string {
+		if len(s) <= length {
+			return s
+		}
+		return s[:length] + etc
+	}
+	if err := gsm.RegisterModifierFunc("truncate", truncate, 10, "..."); err != nil {
+		t.Fatal(err)
+	}
+	if err := gsm.RegisterModifierFunc("yen", func(price int64) (string, error) {
+		if price < 0 {
+			return "", fmt.Errorf("negative price %d", price)
+		}
+		return fmt.Sprintf("¥%d", price), nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := gsm.RegisterModifierFunc("wrap", func(s string, parts ...string) string {
+		return strings.Join(parts, "") + s + strings.Join(parts, "")
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := gsm.RegisterModifierFunc("first", func(s []string) string { return s[0] }); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		input   string
+		want    string
+		wantErr string
+	}{
+		{input: `{$title|truncate}`, want: "Hello, gos..."},
+		{input: `{$title|truncate:5}`, want: "Hello..."},
+		{input: `{$title|truncate:5:"!"}`, want: "Hello!"},
+		{input: `{$title|truncate:100}`, want: "Hello, gosmarty world"},
+		{input: `{$price|yen}`, want: "¥1980"},
+		{input: `{$price - 2000|yen}`, wantErr: "modifier yen: negative price -20"},
+		{input: `{$title|yen}`, wantErr: "modifier yen: input: cannot use"},
+		{input: `{$title|truncate:"a"}`, wantErr: "modifier truncate: argument 1: cannot use"},
+		{input: `{$title|truncate:1:"":3}`, wantErr: "modifier truncate: want 0 to 2 arguments, got 3"},
+		{input: `{$title|wrap}`, want: "Hello, gosmarty world"},
+		{input: `{$price|wrap:"*":"*"}`, want: "**1980**"},
+		{input: `{$tags|@first}`, want: "a"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			tmpl, err := gsm.Parse(tt.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			evaled := tmpl.Execute(env)
+			if tt.wantErr != "" {
+				errObj, ok := evaled.(*object.Error)
+				if !ok {
+					t.Fatalf("want *object.Error, got %#v", evaled)
+				}
+				if !strings.Contains(errObj.Message, tt.wantErr) {
+					t.Errorf("error %q does not contain %q", errObj.Message, tt.wantErr)
+				}
+				return
+			}
+
+			result, ok := evaled.(*object.String)
+			if !ok {
+				t.Fatalf("isn't object.String: %#v", evaled)
+			}
+			if result.Value != tt.want {
+				t.Errorf("result has wrong value. got=%q, want=%q", result.Value, tt.want)
+			}
+		})
+	}
+
+	invalid := []struct {
+		name     string
+		fn       any
+		defaults []any
+	}{
+		{name: "not a function", fn: "truncate"},
+		{name: "no input", fn: func() string { return "" }},
+		{name: "no result", fn: func(s string) {}},
+		{name: "too many defaults", fn: truncate, defaults: []any{1, 2, 3}},
+		{name: "default type mismatch", fn: truncate, defaults: []any{"10", "..."}},
+	}
+	for _, tt := range invalid {
+		if err := gsm.RegisterModifierFunc("invalid", tt.fn, tt.defaults...); err == nil {
+			t.Errorf("%s: RegisterModifierFunc should return an error", tt.name)
+		}
+	}
+}
+
+func TestContextModifier(t *testing.T) {
+	t.Parallel()
+
+	type ctxKey struct{}
+
+	env := Must(NewEnvironment(
+		WithVariable("price", 1200),
+		WithVariable("currency", "JPY"),
+		WithVariable("items", []string{"a", "b"}),
+	))
+	gsm := New(WithLocale("ja_JP"), WithCharset("Shift_JIS"), WithTimezone(time.UTC))
+	gsm.RegisterContextModifier("money", func(rc *modifier.RenderContext, input object.Object, args ...object.Object) (object.Object, error) {
+		format := "%s %s"
+		if len(args) > 0 {
+			format = args[0].Inspect()
+		}
+		if strings.Count(format, "%s") != 2 {
+			return nil, fmt.Errorf("invalid format string %q", format)
+		}
+		currency, _ := rc.GetVar("currency")
+		return object.NewString(fmt.Sprintf(format, currency.Inspect(), input.Inspect())), nil
+	})
+	gsm.RegisterContextModifier("settings", func(rc *modifier.RenderContext, input object.Object, args ...object.Object) (object.Object, error) {
+		user, _ := rc.Context.Value(ctxKey{}).(string)
+		return object.NewString(fmt.Sprintf("%s/%s/%s/%s", rc.Locale, rc.Charset, rc.Location, user)), nil
+	})
+	gsm.RegisterContextModifier("current", func(rc *modifier.RenderContext, input object.Object, args ...object.Object) (object.Object, error) {
+		item, ok := rc.GetVar("item")
+		if !ok {
+			return nil, fmt.Errorf("no current item")
+		}
+		return item, nil
+	})
+	if err := gsm.RegisterModifierFunc("positive", func(n int) (int, error) {
+		if n <= 0 {
+			return 0, fmt.Errorf("%d is not positive", n)
+		}
+		return n, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		input   string
+		want    string
+		wantErr string
+	}{
+		{input: `{$price|money}`, want: "JPY 1200"},
+		{input: `{$price|money:"%s: %s"}`, want: "JPY: 1200"},
+		{input: `{$price|money:"%d"}`, wantErr: `1:9: modifier money: invalid format string "%d"`},
+		{input: "line1\n{$price|upper|money:\"\"}", wantErr: "2:15: modifier money"},
+		{input: `{$price|settings}`, want: "ja_JP/Shift_JIS/UTC/taro"},
+		{input: `{foreach from=$items item=item}{$price|current}{/foreach}`, want: "ab"},
+		{input: `{$price|current}`, wantErr: "no current item"},
+		{input: `{$price|positive}`, want: "1200"},
+		{input: `{$price - 2000|positive}`, wantErr: "1:16: modifier positive: -800 is not positive"},
+	}
+
+	ctx := context.WithValue(context.Background(), ctxKey{}, "taro")
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			tmpl, err := gsm.Parse(tt.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			evaled := tmpl.ExecuteContext(ctx, env)
+			if tt.wantErr != "" {
+				errObj, ok := evaled.(*object.Error)
+				if !ok {
+					t.Fatalf("want *object.Error, got %#v", evaled)
+				}
+				if !strings.Contains(errObj.Message, tt.wantErr) {
+					t.Errorf("error %q does not contain %q", errObj.Message, tt.wantErr)
+				}
+				return
+			}
+
+			result, ok := evaled.(*object.String)
+			if !ok {
+				t.Fatalf("isn't object.String: %#v", evaled)
+			}
+			if result.Value != tt.want {
+				t.Errorf("result has wrong value. got=%q, want=%q", result.Value, tt.want)
+			}
+		})
+	}
+}
+
+func TestArrayModifiers(t *testing.T) {
+	t.Parallel()
+
+	env := Must(NewEnvironment(
+		WithVariable("tags", []string{"go", "php", "smarty"}),
+		WithVariable("nums", []int{3, 10, 1}),
+		WithVariable("empty", []string{}),
+		WithVariable("prices", map[string]any{"b": 200, "a": 100}),
+		WithVariable("name", "gosmarty"),
+		WithVariable("needle", "php"),
+		WithVariable("pair", []int{1, 2}),
+		WithVariable("pairs", [][]int{{2, 1}, {1, 2}}),
+		WithVariable("strPairs", [][]string{{"1", "2"}}),
+		WithLazyVariable("item", struct{ Name, Code string }{Name: "mug", Code: "ab"}),
+	))
+	tests := []struct {
+		input      string
+		comparison Comparison
+		want       string
+	}{
+		{input: `{$tags|@count}`, want: "3"},
+		{input: `{$empty|@count}`, want: "0"},
+		{input: `{$prices|@count}`, want: "2"},
+		{input: `{$tags|@implode:", "}`, want: "go, php, smarty"},
+		{input: `{$tags|@join:"/"}`, want: "go/php/smarty"},
+		{input: `{$tags|upper|@join:","}`, want: "GO,PHP,SMARTY"},
+		{input: `{$tags|@json_encode}`, want: `["go","php","smarty"]`},
+		{input: `{$prices|@json_encode}`, want: `{"a":100,"b":200}`},
+		{input: `{$prices|@array_keys|@implode:","}`, want: "a,b"},
+		{input: `{$tags|@array_keys|@implode:","}`, want: "0,1,2"},
+		{input: `{if $needle|in_array:$tags}yes{else}no{/if}`, want: "yes"},
+		{input: `{if "java"|in_array:$tags}yes{else}no{/if}`, want: "no"},
+		// in_array はエンジンの比較の規則で比較し、3つ目の引数が true なら型も比較する
+		{input: `{if 3|in_array:$nums}yes{else}no{/if}`, want: "yes"},
+		{input: `{if "3"|in_array:$nums}yes{else}no{/if}`, want: "no"},
+		{input: `{if "3"|in_array:$nums}yes{else}no{/if}`, comparison: ComparisonPHP, want: "yes"},
+		{input: `{if "3"|in_array:$nums:true}yes{else}no{/if}`, comparison: ComparisonPHP, want: "no"},
+		{input: `{if 3|in_array:$nums:true}yes{else}no{/if}`, comparison: ComparisonPHP, want: "yes"},
+		{input: `{if 0|in_array:$tags}yes{else}no{/if}`, comparison: ComparisonPHP, want: "no"},
+		// 配列の要素は === と同じくキーと値の組を再帰的に比較する
+		{input: `{if $pair|@in_array:$pairs:true}yes{else}no{/if}`, want: "yes"},
+		{input: `{if $pair|@in_array:$strPairs:true}yes{else}no{/if}`, want: "no"},
+		{input: `{if $pair|@in_array:$strPairs}yes{else}no{/if}`, comparison: ComparisonPHP, want: "yes"},
+		{input: `{$nums|@sort|@implode:","}`, want: "1,3,10"},
+		{input: `{$tags|@reverse|@implode:","}`, want: "smarty,php,go"},
+		{input: `{$name|reverse}`, want: "ytramsog"},
+		{input: `{$tags|@slice:1|@implode:","}`, want: "php,smarty"},
+		{input: `{$tags|@slice:0:2|@implode:","}`, want: "go,php"},
+		{input: `{$tags|@slice:-2:1|@implode:","}`, want: "php"},
+		// マップは値を並べた配列として扱う
+		{input: `{$prices|@sort|@implode:","}`, want: "100,200"},
+		{input: `{$prices|@reverse|@implode:","}`, want: "200,100"},
+		{input: `{$prices|@slice:1|@implode:","}`, want: "200"},
+		// '@' のない修飾子は構造体のフィールドにもそれぞれ適用し、キーの順序を保つ
+		{input: `{$item|upper|@implode:","}`, want: "MUG,AB"},
+		{input: `{$item|upper|@array_keys|@implode:","}`, want: "Name,Code"},
+		{input: `{$name|slice:2:5}`, want: "smart"},
+		// '@' がない修飾子は要素ごとに適用される
+		{input: `{$tags|count|@implode:","}`, want: "1,1,1"},
+		{input: `{$tags|slice:0:1|@implode:""}`, want: "gps"},
+		{input: `{$nums|@count}`, want: "3"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			tmpl, err := New(WithComparison(tt.comparison)).Parse(tt.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			evaled := tmpl.Execute(env)
+			result, ok := evaled.(*object.String)
+			if !ok {
+				t.Fatalf("isn't object.String: %#v", evaled)
+			}
+			if result.Value != tt.want {
+				t.Errorf("result has wrong value. got=%q, want=%q", result.Value, tt.want)
+			}
+		})
+	}
+}
+
+func TestExactNumbers(t *testing.T) {
+	t.Parallel()
+
+	env := Must(NewEnvironment(
+		WithVariable("orderID", int64(1234567890123456789)),
+		WithVariable("big", new(big.Int).Lsh(big.NewInt(1), 70)),
+		WithVariable("price", big.NewRat(1999, 100)),
+		WithVariable("amount", 1234567),
+		WithVariable("ratio", 1.005),
+		WithVariable("items", []string{"a", "b"}),
+		WithVariable("salary", 1500000.0),
+		WithJSONVariable("totals", []byte(`{"sum": 12345678901234567.89, "count": 3}`)),
+	))
+	gsm := New()
+
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{input: `{$orderID}`, want: "1234567890123456789"},
+		{input: `{$orderID + 1}`, want: "1234567890123456790"},
+		{input: `{if $orderID == 1234567890123456789}eq{/if}`, want: "eq"},
+		{input: `{if $orderID > 1234567890123456788}gt{/if}`, want: "gt"},
+		{input: `{$big}`, want: "1180591620717411303424"},
+		{input: `{$amount}`, want: "1234567"},
+		{input: `{$price}`, want: "19.99"},
+		{input: `{$price + 0.01}`, want: "20"},
+		{input: `{(0.1 + 0.2)}`, want: "0.3"},
+		{input: `{if (0.1 + 0.2) == 0.3}exact{/if}`, want: "exact"},
+		{input: `{$amount|number_format}`, want: "1,234,567"},
+		{input: `{$price|number_format:2}`, want: "19.99"},
+		{input: `{$price|number_format:1:",":"."}`, want: "20,0"},
+		{input: `{$orderID|number_format}`, want: "1,234,567,890,123,456,789"},
+		{input: `{$ratio|number_format:2}`, want: "1.01"},
+		{input: `{$amount - 1234567.5|number_format}`, want: "-1"},
+		{input: `{$items[1]}`, want: "b"},
+		{input: `{foreach from=$items key=i item=v}{$i + 1}{/foreach}`, want: "12"},
+		{input: `{$salary}`, want: "1500000"},
+		{input: `{$totals|@json_encode}`, want: `{"sum":12345678901234567.89,"count":3}`},
+		{input: `{[$price, $totals.sum]|@json_encode}`, want: `[19.99,12345678901234567.89]`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			tmpl, err := gsm.Parse(tt.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			evaled := tmpl.Execute(env)
+			result, ok := evaled.(*object.String)
+			if !ok {
+				t.Fatalf("isn't object.String: %#v", evaled)
+			}
+			if result.Value != tt.want {
+				t.Errorf("result has wrong value. got=%q, want=%q", result.Value, tt.want)
+			}
+		})
+	}
+}
+
+func TestOrderedMap(t *testing.T) {
+	t.Parallel()
+
+	type setting struct {
+		Zone   string
+		Amount int
+		Label  string
+	}
+	env := Must(NewEnvironment(
+		WithJSONVariable("settings", []byte(`{"theme": "dark", "lang": "ja", "beta": true}`)),
+		WithJSONVariable("ranks", []byte(`{"10": "gold", "2": "silver"}`)),
+		WithVariable("setting", setting{Zone: "jp", Amount: 3, Label: "x"}),
+		WithVariable("plain", map[string]any{"b": 2, "a": 1}),
+		WithVariable("levels", map[int]string{10: "gold", 2: "silver", 1: "bronze"}),
+		WithLazyVariable("profile", struct {
+			Name  string `gosmarty:"name" json:"full_name"`
+			Price *big.Int
+			Tags  []string `json:"tags,omitempty"`
+		}{Name: "mug", Price: big.NewInt(1500)}),
+	))
+
+	tests := []struct {
+		input string
+		order MapOrder
+		want  string
+	}{
+		{input: `{foreach from=$settings key=k item=v}{$k}={$v};{/foreach}`, want: "theme=dark;lang=ja;beta=true;"},
+		{input: `{foreach from=$setting key=k item=v}{$k}={$v};{/foreach}`, want: "Zone=jp;Amount=3;Label=x;"},
+		{input: `{foreach from=$plain key=k item=v}{$k}={$v};{/foreach}`, want: "a=1;b=2;"},
+		{input: `{foreach from=$ranks key=k item=v}{$k + 1}:{$v};{/foreach}`, want: "11:gold;3:silver;"},
+		{input: `{$settings.lang}`, want: "ja"},
+		{input: `{foreach from=$levels key=k item=v}{$k + 1}:{$v};{/foreach}`, want: "2:bronze;3:silver;11:gold;"},
+		{input: `{$levels[2]}/{$levels.10}`, want: "silver/gold"},
+		{input: `{$settings|@array_keys|@implode:","}`, want: "theme,lang,beta"},
+		{input: `{$settings|upper|@implode:","}`, want: "DARK,JA,"},
+		{input: `{$settings|@json_encode}`, want: `{"theme":"dark","lang":"ja","beta":true}`},
+		{input: `{$ranks|@json_encode}`, want: `{"10":"gold","2":"silver"}`},
+		{input: `{foreach from=$settings key=k item=v}{$k};{/foreach}`, order: MapOrderSorted, want: "beta;lang;theme;"},
+		{input: `{foreach from=$setting key=k item=v}{$k};{/foreach}`, order: MapOrderSorted, want: "Amount;Label;Zone;"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			tmpl, err := New(WithMapOrder(tt.order)).Parse(tt.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			evaled := tmpl.Execute(env)
+			result, ok := evaled.(*object.String)
+			if !ok {
+				t.Fatalf("isn't object.String: %#v", evaled)
+			}
+			if result.Value != tt.want {
+				t.Errorf("result has wrong value. got=%q, want=%q", result.Value, tt.want)
+			}
+		})
+	}
+
+	// <script> の中に出力する場合も json_encode と同じJSONになる
+	scripts := []struct {
+		name string
+		want string
+	}{
+		{name: "settings", want: `{"theme":"dark","lang":"ja","beta":true}`},
+		{name: "levels", want: `{"1":"bronze","2":"silver","10":"gold"}`},
+		{name: "profile", want: `{"name":"mug","Price":1500}`},
+	}
+	for _, tt := range scripts {
+		tmpl, err := New(WithContextualEscaping(true)).Parse(`<script>var s = {$` + tt.name + `};</script>{$` + tt.name + `|@json_encode}`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := `<script>var s = ` + tt.want + `;</script>` + html.EscapeString(tt.want)
+		if got := tmpl.Execute(env).Inspect(); got != want {
+			t.Errorf("%s: got=%q, want=%q", tt.name, got, want)
+		}
+	}
+}
+
+func TestSequence(t *testing.T) {
+	t.Parallel()
+
+	seq := func(yield func(string) bool) {
+		for _, s := range []string{"a", "b", "c"} {
+			if !yield(s) {
+				return
+			}
+		}
+	}
+	seq2 := func(yield func(string, int) bool) {
+		for i, s := range []string{"x", "y"} {
+			if !yield(s, i*10) {
+				return
+			}
+		}
+	}
+	newChan := func() <-chan int {
+		ch := make(chan int, 3)
+		ch <- 1
+		ch <- 2
+		ch <- 3
+		close(ch)
+		return ch
+	}
+
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{input: `{foreach from=$seq item=v}{$v}{/foreach}`, want: "abc"},
+		{input: `{foreach from=$seq key=i item=v}{$i}:{$v};{/foreach}`, want: "0:a;1:b;2:c;"},
+		{input: `{foreach from=$seq2 key=k item=v}{$k}={$v};{/foreach}`, want: "x=0;y=10;"},
+		{input: `{foreach from=$ch item=v}{$v}{if $v@last}.{else},{/if}{/foreach}`, want: "1,2,3."},
+		{input: `{foreach from=$seq item=v}{$v@index}/{$v@iteration}/{$v@first}/{$v@last};{/foreach}`, want: "0/1/true/false;1/2/false/false;2/3/false/true;"},
+		{input: `{foreach from=$seq item=v}[{$v@total}]{/foreach}`, want: "[][][]"},
+		{input: `[{$seq|@count}][{$ch|@count|default:"?"}]`, want: "[][?]"},
+		{input: `{foreach from=$list item=v}{$v@total}{/foreach}`, want: "22"},
+		{input: `{foreach from=$seq item=v name=outer}{foreach from=$list item=v}{$v@index}{/foreach}{/foreach}`, want: "010101"},
+		{input: `{foreach from=$empty item=v}{$v}{foreachelse}none{/foreach}`, want: "none"},
+		{input: `{foreach from=$seq item=v name=s}{if $smarty.foreach.s.last}{$v}{/if}{/foreach}`, want: "c"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			env := Must(NewEnvironment(
+				WithVariable("seq", iter.Seq[string](seq)),
+				WithVariable("seq2", iter.Seq2[string, int](seq2)),
+				WithVariable("ch", newChan()),
+				WithVariable("list", []int{1, 2}),
+				WithVariable("empty", iter.Seq[int](func(func(int) bool) {})),
+			))
+			tmpl, err := New().Parse(tt.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			evaled := tmpl.Execute(env)
+			result, ok := evaled.(*object.String)
+			if !ok {
+				t.Fatalf("isn't object.String: %#v", evaled)
+			}
+			if result.Value != tt.want {
+				t.Errorf("result has wrong value. got=%q, want=%q", result.Value, tt.want)
+			}
+		})
+	}
+
+	t.Run("stops the sequence on error", func(t *testing.T) {
+		var produced int
+		stopped := false
+		rows := func(yield func(int) bool) {
+			for i := range 100 {
+				produced++
+				if !yield(i) {
+					stopped = true
+					return
+				}
+			}
+		}
+		env := Must(NewEnvironment(WithVariable("rows", iter.Seq[int](rows))))
+		tmpl, err := New().Parse(`{foreach from=$rows item=v}{if $v == 2}{undefined_fn()}{/if}{$v}{/foreach}`)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		evaled := tmpl.Execute(env)
+		if _, ok := evaled.(*object.Error); !ok {
+			t.Fatalf("expected object.Error, got %#v", evaled)
+		}
+		if !stopped || produced > 4 {
+			t.Errorf("sequence was not stopped early: produced=%d, stopped=%v", produced, stopped)
+		}
+	})
+
+	t.Run("reads ahead only for @last", func(t *testing.T) {
+		for _, tt := range []struct {
+			input string
+			left  int // ループを止めた後にチャネルに残る要素の数
+		}{
+			{input: `{foreach from=$ch item=v}{if $v == 2}{undefined_fn()}{/if}{/foreach}`, left: 1},
+			{input: `{foreach from=$ch item=v}{if $v == 2}{undefined_fn()}{/if}{$v@first}{/foreach}`, left: 1},
+			// 最後の要素かどうかを判定するために、次の要素を読み出す
+			{input: `{foreach from=$ch item=v}{if $v@last}.{/if}{if $v == 2}{undefined_fn()}{/if}{/foreach}`, left: 0},
+		} {
+			ch := newChan()
+			env := Must(NewEnvironment(WithVariable("ch", ch)))
+			tmpl, err := New().Parse(tt.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := tmpl.Execute(env).(*object.Error); !ok {
+				t.Fatalf("%s: expected object.Error", tt.input)
+			}
+			if len(ch) != tt.left {
+				t.Errorf("%s: got %d elements left, want %d", tt.input, len(ch), tt.left)
+			}
+		}
+	})
+
+	t.Run("reports elements that cannot be converted", func(t *testing.T) {
+		ch := make(chan any, 2)
+		ch <- "a"
+		ch <- func() {}
+		close(ch)
+		env := Must(NewEnvironment(WithVariable("ch", ch)))
+		tmpl, err := New().Parse(`{foreach from=$ch item=v}{$v}{/foreach}`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		errObj, ok := tmpl.Execute(env).(*object.Error)
+		if !ok || !strings.Contains(errObj.Message, "unsupported type: func()") {
+			t.Errorf("want conversion error, got %#v", errObj)
+		}
+	})
+}
+
+var (
+	moneyType         = object.RegisterObjectType("money")
+	localizedTextType = object.RegisterObjectType("localized_text")
+	imageType         = object.RegisterObjectType("image")
+)
+
+type money struct {
+	Amount   int64
+	Currency string
+}
+
+func (m money) Type() object.ObjectType { return moneyType }
+func (m money) Inspect() string         { return fmt.Sprintf("%d %s", m.Amount, m.Currency) }
+func (m money) Truthy() bool            { return m.Amount != 0 }
+
+func (m money) Compare(other object.Object) (int, bool) {
+	switch o := other.(type) {
+	case money:
+		if o.Currency != m.Currency {
+			return 0, false
+		}
+		return cmp.Compare(m.Amount, o.Amount), true
+	case *object.Integer:
+		return big.NewInt(m.Amount).Cmp(o.Value), true
+	}
+	return 0, false
+}
+
+func (m money) Field(name string) (object.Object, bool) {
+	switch name {
+	case "amount":
+		return object.NewInteger(m.Amount), true
+	case "currency":
+		return object.NewString(m.Currency), true
+	}
+	return nil, false
+}
+
+type localizedText map[string]string
+
+func (t localizedText) Type() object.ObjectType { return localizedTextType }
+func (t localizedText) Inspect() string         { return t["en"] }
+
+func (t localizedText) Index(key object.Object) (object.Object, bool) {
+	s, ok := t[key.Inspect()]
+	if !ok {
+		return nil, false
+	}
+	return object.NewString(s), true
+}
+
+func (t localizedText) All() iter.Seq2[object.Object, object.Object] {
+	return func(yield func(object.Object, object.Object) bool) {
+		for _, lang := range slices.Sorted(maps.Keys(t)) {
+			if !yield(object.NewString(lang), object.NewString(t[lang])) {
+				return
+			}
+		}
+	}
+}
+
+func (t localizedText) Len() int { return len(t) }
+
+type image struct {
+	Src, Alt string
+}
+
+func (i *image) Type() object.ObjectType { return imageType }
+func (i *image) Inspect() string         { return i.Src }
+
+func (i *image) Render() object.Object {
+	return object.NewHTML(fmt.Sprintf(`<img src="%s" alt="%s">`, html.EscapeString(i.Src), html.EscapeString(i.Alt)))
+}
+
+func TestCapabilityInterfaces(t *testing.T) {
+	t.Parallel()
+
+	env := Must(NewEnvironment(
+		WithVariable("price", money{Amount: 1200, Currency: "JPY"}),
+		WithVariable("free", money{Amount: 0, Currency: "JPY"}),
+		WithVariable("usd", money{Amount: 10, Currency: "USD"}),
+		WithVariable("title", localizedText{"en": "Hello", "ja": "こんにちは"}),
+		WithVariable("logo", &image{Src: "/logo.png", Alt: `"Acme" & co`}),
+		WithVariable("noImage", (*image)(nil)),
+		WithVariable("product", map[string]any{"price": money{Amount: 500, Currency: "JPY"}}),
+	))
+
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{input: `{$price}`, want: "1200 JPY"},
+		{input: `{if $price}paid{/if}{if $free}paid{else}free{/if}`, want: "paidfree"},
+		{input: `{$price.amount}/{$price.currency}`, want: "1200/JPY"},
+		{input: `{if $price > $product.price}more{/if}`, want: "more"},
+		{input: `{if $price == 1200}eq{/if}{if 1000 < $price}lt{/if}`, want: "eqlt"},
+		{input: `{if $price == $usd}eq{else}ne{/if}{if $price > $usd}gt{else}ng{/if}`, want: "neng"},
+		{input: `{$title}/{$title["ja"]}/{$title["fr"]}`, want: "Hello/こんにちは/"},
+		{input: `{foreach from=$title key=lang item=text}{$lang}={$text}({$text@total});{/foreach}`, want: "en=Hello(2);ja=こんにちは(2);"},
+		{input: `{$logo}`, want: `<img src="/logo.png" alt="&#34;Acme&#34; &amp; co">`},
+		{input: `[{$noImage}]`, want: "[]"},
+		// 文字列に埋め込んだ場合も Render の結果を使う
+		{input: `{"[{$logo}]" nofilter}`, want: `[<img src="/logo.png" alt="&#34;Acme&#34; &amp; co">]`},
+		{input: `{foreach from=[$logo] item=img}{$img nofilter}{/foreach}`, want: `<img src="/logo.png" alt="&#34;Acme&#34; &amp; co">`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			tmpl, err := New(WithEscapeHTML(true)).Parse(tt.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			evaled := tmpl.Execute(env)
+			result, ok := evaled.(*object.String)
+			if !ok {
+				t.Fatalf("isn't object.String: %#v", evaled)
+			}
+			if result.Value != tt.want {
+				t.Errorf("result has wrong value. got=%q, want=%q", result.Value, tt.want)
+			}
+		})
+	}
+
+	if got := moneyType.String(); got != "money" {
+		t.Errorf("moneyType.String() = %q, want %q", got, "money")
+	}
+	// 発行した ObjectType は組み込みの型とも、ほかに発行した型とも重ならない
+	for _, typ := range []object.ObjectType{moneyType, localizedTextType, imageType} {
+		if typ <= object.SequenceType {
+			t.Errorf("%s: ObjectType %d overlaps the built-in types", typ, int(typ))
+		}
+	}
+	if moneyType == localizedTextType || localizedTextType == imageType || moneyType == imageType {
+		t.Error("registered object types should be distinct")
+	}
+}
+
+func TestIndexAccess(t *testing.T) {
+	t.Parallel()
+
+	env := Must(NewEnvironment(
+		WithVariable("items", []string{"a", "b", "c"}),
+		WithVariable("matrix", [][]int{{1, 2}, {3, 4}}),
+		WithVariable("i", 1),
+		WithVariable("lang", "ja"),
+		WithVariable("field", "title"),
+		WithVariable("translations", map[string]any{
+			"ja": map[string]any{"title": "タイトル"},
+			"en": map[string]any{"title": "Title"},
+		}),
+		WithJSONVariable("ranks", []byte(`{"10": "gold", "2": "silver", "-1": "none"}`)),
+	))
+
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{input: `{$items[0]}{$items[$i]}{$items[$i + 1]}`, want: "abc"},
+		{input: `{$items[-1]}{$items[-3]}[{$items[-4]}][{$items[3]}]`, want: "ca[][]"},
+		{input: `{$items["1"]}[{$items["x"]}]`, want: "b[]"},
+		{input: `{$items.0}{$items.2}`, want: "ac"},
+		{input: `{$matrix.1.0}{$matrix[0][1]}{$matrix.1[1]}`, want: "324"},
+		{input: `{$translations["ja"]["title"]}`, want: "タイトル"},
+		{input: `{$translations[$lang].title}`, want: "タイトル"},
+		{input: `{$translations.$lang.title}`, want: "タイトル"},
+		{input: `{$translations.en.$field}`, want: "Title"},
+		{input: `[{$translations.fr.title}][{$translations[$missing]}]`, want: "[][]"},
+		{input: `{$ranks.10}{$ranks[2]}{$ranks["-1"]}{$ranks[-1]}`, want: "goldsilvernonenone"},
+		{input: `{foreach from=$items key=k item=v}{$items[$k]}{/foreach}`, want: "abc"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			tmpl, err := New().Parse(tt.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			evaled := tmpl.Execute(env)
+			result, ok := evaled.(*object.String)
+			if !ok {
+				t.Fatalf("isn't object.String: %#v", evaled)
+			}
+			if result.Value != tt.want {
+				t.Errorf("result has wrong value. got=%q, want=%q", result.Value, tt.want)
+			}
+		})
+	}
+}
+
+func TestArrayLiterals(t *testing.T) {
+	t.Parallel()
+
+	env := Must(NewEnvironment(
+		WithVariable("x", 42),
+		WithVariable("items", []string{"a", "b"}),
+	))
+	engine := New().Funcs(map[string]any{
+		"sum": func(values []int) int {
+			total := 0
+			for _, v := range values {
+				total += v
+			}
+			return total
+		},
+		"label": func(m map[string]string, key string) string { return m[key] },
+	})
+
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{input: `{foreach from=[1, 2, 3] item=v}{$v};{/foreach}`, want: "1;2;3;"},
+		{input: `{foreach from=["a" => 1, "b" => $x] key=k item=v}{$k}={$v};{/foreach}`, want: "a=1;b=42;"},
+		{input: `{foreach from=array("red", "green",) key=k item=v}{$k}:{$v};{/foreach}`, want: "0:red;1:green;"},
+		{input: `{foreach from=array(5 => "five", "six", "k" => "v", "seven") key=k item=v}{$k}:{$v};{/foreach}`, want: "5:five;6:six;k:v;7:seven;"},
+		{input: `{[10, 20, 30][1]}{["a" => "A"].a}{[$items, "c"][0][1]}`, want: "20Ab"},
+		{input: `{foreach from=[] item=v}{$v}{foreachelse}empty{/foreach}`, want: "empty"},
+		{input: `{sum([1, 2, $x])}`, want: "45"},
+		{input: `{label(["ok" => "OK", "ng" => "NG"], "ng")}`, want: "NG"},
+		{input: `{[1, 2, 3]|@count}`, want: "3"},
+		{input: `{assign var="sizes" value=["S", "M", "L"]}{foreach from=$sizes item=s}{$s}{/foreach}`, want: "SML"},
+		{input: `{assign var=opts value=["a" => 1]|@json_encode}{$opts}`, want: `{"a":1}`},
+		{input: `{assign var="total" value=$x + 1}{$total}`, want: "43"},
+		{input: `{foreach from=[1, 2] item=v}{assign var="last" value=$v}{/foreach}{$last}`, want: "2"},
+		{input: `{foreach from=[1, 2] item=v}{assign var="v" value=$v + 10}{$v};{/foreach}`, want: "11;12;"},
+		{input: `{assign var="x" value=1}{$x}`, want: "1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			tmpl, err := engine.Parse(tt.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			evaled := tmpl.Execute(env)
+			result, ok := evaled.(*object.String)
+			if !ok {
+				t.Fatalf("isn't object.String: %#v", evaled)
+			}
+			if result.Value != tt.want {
+				t.Errorf("result has wrong value. got=%q, want=%q", result.Value, tt.want)
+			}
+		})
+	}
+
+	// {assign} は共有の Environment を書き換えない
+	tmpl, err := engine.Parse(`{assign var="x" value=1}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl.Execute(env)
+	if x, _ := env.GetVar("x"); !reflect.DeepEqual(x, object.NewInteger(42)) {
+		t.Errorf("assign should not modify the shared environment: got=%#v", x)
+	}
+
+	for _, input := range []string{`{[1, 2}`, `{array(1, 2}`, `{assign var="x"}`, `{assign value=1}`} {
+		if _, err := engine.Parse(input); err == nil {
+			t.Errorf("%s: want parse error", input)
+		}
+	}
+}
+
+func TestStringInterpolation(t *testing.T) {
+	t.Parallel()
+
+	env := Must(NewEnvironment(
+		WithVariable("name", "Alice"),
+		WithVariable("item", map[string]any{"id": 7, "title": "<b>Tea</b>"}),
+		WithVariable("user", map[string]any{"name": "bob"}),
+		WithVariable("items", []string{"a", "b"}),
+	))
+	engine := New(WithEscapeHTML(true)).Funcs(map[string]any{
+		"greet": func(s string) string { return "[" + s + "]" },
+	})
+
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{input: `{"Hello $name!"}`, want: "Hello Alice!"},
+		{input: `{'Hello $name!'}`, want: "Hello $name!"},
+		{input: `{"/img/{$item.id}.png"}`, want: "/img/7.png"},
+		{input: "{\"`$user.name`\"}", want: "bob"},
+		{input: `{"{$user.name|upper} and {$name|escape:"url"}"}`, want: "BOB and Alice"},
+		{input: `{"$name's $missing cup"}`, want: "Alice&#39;s  cup"},
+		{input: `{"{$item.title}"}`, want: "&lt;b&gt;Tea&lt;/b&gt;"},
+		{input: `{"cost: \$5, \"quoted\", {literal brace}"}`, want: "cost: $5, &#34;quoted&#34;, {literal brace}"},
+		{input: `{"$ alone and 100$"}`, want: "$ alone and 100$"},
+		{input: `{greet("hi $name")}`, want: "[hi Alice]"},
+		{input: `{foreach from=$items item=v}{"$v-{$v@index}"};{/foreach}`, want: "a-0;b-1;"},
+		{input: `{assign var="path" value="/users/{$user.name}"}{$path}`, want: "/users/bob"},
+		{input: `{if "$name" == "Alice"}yes{/if}`, want: "yes"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			tmpl, err := engine.Parse(tt.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			evaled := tmpl.Execute(env)
+			result, ok := evaled.(*object.String)
+			if !ok {
+				t.Fatalf("isn't object.String: %#v", evaled)
+			}
+			if result.Value != tt.want {
+				t.Errorf("result has wrong value. got=%q, want=%q", result.Value, tt.want)
+			}
+		})
+	}
+
+	for _, input := range []string{"{\"`$name\"}", `{"{$name"}`, `{"{$name|}"}`} {
+		if _, err := engine.Parse(input); err == nil {
+			t.Errorf("%s: want parse error", input)
+		}
+	}
+}
+
+func TestComparison(t *testing.T) {
+	t.Parallel()
+
+	env := Must(NewEnvironment(
+		WithVariable("count", 3),
+		WithVariable("price", 1.5),
+		WithVariable("flag", true),
+		WithVariable("zero", "0"),
+		WithVariable("empty", ""),
+		WithVariable("name", "abc"),
+		WithVariable("nums", []int{1, 2}),
+		WithVariable("strs", []string{"1", "2"}),
+		WithVariable("m1", map[string]any{"a": 1, "b": 2}),
+		WithVariable("m2", map[string]any{"b": "2", "a": "1"}),
+		WithVariable("m3", map[string]any{"a": 1, "c": 2}),
+	))
+
+	tests := []struct {
+		input string
+		typed string
+		php   string
+	}{
+		{input: `{if $count == "3"}y{else}n{/if}`, typed: "n", php: "y"},
+		{input: `{if $count == " 3 "}y{else}n{/if}`, typed: "n", php: "y"},
+		{input: `{if $count == "3abc"}y{else}n{/if}`, typed: "n", php: "n"},
+		{input: `{if $price == "1.50"}y{else}n{/if}`, typed: "n", php: "y"},
+		{input: `{if "1e3" == "1000"}y{else}n{/if}`, typed: "n", php: "y"},
+		{input: `{if "abc" == 0}y{else}n{/if}`, typed: "n", php: "n"},
+		{input: `{if $flag == 1}y{else}n{/if}`, typed: "n", php: "y"},
+		{input: `{if $flag == "yes"}y{else}n{/if}`, typed: "n", php: "y"},
+		{input: `{if $zero == false}y{else}n{/if}`, typed: "n", php: "y"},
+		{input: `{if $missing == 0}y{else}n{/if}`, typed: "n", php: "y"},
+		{input: `{if $missing == ""}y{else}n{/if}`, typed: "n", php: "y"},
+		{input: `{if $missing == "0"}y{else}n{/if}`, typed: "n", php: "n"},
+		{input: `{if $missing < -1}y{else}n{/if}`, typed: "n", php: "y"},
+		{input: `{if $nums == $strs}y{else}n{/if}`, typed: "n", php: "y"},
+		{input: `{if $m1 == $m2}y{else}n{/if}`, typed: "n", php: "y"},
+		{input: `{if $m1 == $m3}y{else}n{/if}`, typed: "n", php: "n"},
+		{input: `{if $nums < [1, 2, 3]}y{else}n{/if}`, typed: "n", php: "y"},
+		{input: `{if $nums > 100}y{else}n{/if}`, typed: "n", php: "y"},
+		{input: `{if $zero}y{else}n{/if}`, typed: "y", php: "n"},
+		{input: `{if $name and $zero}y{else}n{/if}`, typed: "y", php: "n"},
+		{input: `{if "apple" < "banana"}y{else}n{/if}`, typed: "y", php: "y"},
+		{input: `{if "10" > "9"}y{else}n{/if}`, typed: "n", php: "y"},
+		{input: `{if $name >= "abc"}y{else}n{/if}`, typed: "y", php: "y"},
+		{input: `{if $count === 3}y{else}n{/if}`, typed: "y", php: "y"},
+		{input: `{if $count === "3"}y{else}n{/if}`, typed: "n", php: "n"},
+		{input: `{if $count !== "3"}y{else}n{/if}`, typed: "y", php: "y"},
+		{input: `{if $nums === [1, 2]}y{else}n{/if}`, typed: "y", php: "y"},
+		{input: `{if $nums === $strs}y{else}n{/if}`, typed: "n", php: "n"},
+		{input: `{if $m1 === ["a" => 1, "b" => 2]}y{else}n{/if}`, typed: "y", php: "y"},
+		{input: `{if $m1 === ["b" => 2, "a" => 1]}y{else}n{/if}`, typed: "n", php: "n"},
+		{input: `{if $m1 == ["b" => 2, "a" => 1]}y{else}n{/if}`, typed: "n", php: "y"},
+		{input: `{if $missing === null}y{else}n{/if}`, typed: "y", php: "y"},
+		{input: `{if $missing === false}y{else}n{/if}`, typed: "n", php: "n"},
+		{input: `{if $flag === TRUE}y{else}n{/if}`, typed: "y", php: "y"},
+	}
+
+	for _, tt := range tests {
+		for _, mode := range []struct {
+			comparison Comparison
+			want       string
+		}{
+			{ComparisonTyped, tt.typed},
+			{ComparisonPHP, tt.php},
+		} {
+			t.Run(fmt.Sprintf("%s/%d", tt.input, mode.comparison), func(t *testing.T) {
+				tmpl, err := New(WithComparison(mode.comparison)).Parse(tt.input)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				evaled := tmpl.Execute(env)
+				result, ok := evaled.(*object.String)
+				if !ok {
+					t.Fatalf("isn't object.String: %#v", evaled)
+				}
+				if result.Value != mode.want {
+					t.Errorf("result has wrong value. got=%q, want=%q", result.Value, mode.want)
+				}
+			})
+		}
+	}
+}
+
+func TestPresenceFunctions(t *testing.T) {
+	t.Parallel()
+
+	name := "Alice"
+	type profile struct {
+		Nickname *string
+		Bio      *string
+	}
+	env := Must(NewEnvironment(
+		WithVariable("user", map[string]any{
+			"name":    "Alice",
+			"address": map[string]any{"city": "Tokyo", "zip": nil},
+			"tags":    []string{},
+		}),
+		WithVariable("profile", profile{Nickname: &name}),
+		WithVariable("list", []int{1, 2, 3}),
+		WithVariable("zero", 0),
+		WithVariable("nothing", nil),
+	))
+	engine := New()
+
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{input: `{isset($user.name)}/{isset($user.address.city)}/{isset($user.address.zip)}/{isset($user.phone.number)}`, want: "true/true/false/false"},
+		{input: `{isset($missing)}/{isset($missing.a.b.c)}/{isset($nothing)}`, want: "false/false/false"},
+		{input: `{isset($user.name, $list)}/{isset($user.name, $missing)}`, want: "true/false"},
+		{input: `{isset($profile.Nickname)}/{isset($profile.Bio)}/{isset($profile.Bio.length)}`, want: "true/false/false"},
+		{input: `{isset($list[1])}/{isset($list[5])}/{isset($missing[0])}`, want: "true/false/false"},
+		{input: `{empty($user.tags)}/{empty($list)}/{empty($zero)}/{empty($missing.a)}/{empty($user.name)}`, want: "true/false/true/true/false"},
+		{input: `{is_array($list)}/{is_array($user)}/{is_array($user.name)}/{is_array($missing)}`, want: "true/true/false/false"},
+		{input: `{is_string($user.name)}/{is_numeric("12.5")}/{is_numeric("12a")}/{is_numeric($zero)}/{is_null($missing.x)}`, want: "true/true/false/true/true"},
+		{input: `{count($list)}/{count($user)}/{count($missing)}/{count($user.tags)}`, want: "3/3/0/0"},
+		{input: `{if isset($user.phone)}{$user.phone.number}{else}no phone{/if}`, want: "no phone"},
+		{input: `{if empty($user.tags)}no tags{/if}`, want: "no tags"},
+		{input: `[{$missing.a.b}][{$nothing.a[0]}][{$profile.Bio.x}][{$nothing->getName()}]`, want: "[][][][]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			tmpl, err := engine.Parse(tt.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			evaled := tmpl.Execute(env)
+			result, ok := evaled.(*object.String)
+			if !ok {
+				t.Fatalf("isn't object.String: %#v", evaled)
+			}
+			if result.Value != tt.want {
+				t.Errorf("result has wrong value. got=%q, want=%q", result.Value, tt.want)
+			}
+		})
+	}
+
+	t.Run("Funcs override builtins", func(t *testing.T) {
+		tmpl, err := New().Funcs(map[string]any{"count": func(any) string { return "custom" }}).Parse(`{count($list)}`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := tmpl.Execute(env).Inspect(); got != "custom" {
+			t.Errorf("got=%q", got)
+		}
+	})
+
+	t.Run("argument is evaluated once", func(t *testing.T) {
+		calls := 0
+		tmpl, err := New().Funcs(map[string]any{"next": func() int { calls++; return calls }}).
+			Parse(`{if empty(next())}empty{else}{next()}{/if}`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := tmpl.Execute(env).Inspect(); got != "2" || calls != 2 {
+			t.Errorf("got=%q, calls=%d", got, calls)
+		}
+	})
+
+	for input, want := range map[string]string{
+		`{isset()}`:       "1:2: isset: want at least 1 argument, got 0",
+		`{empty($a, $b)}`: "1:2: empty: want 1 argument, got 2",
+		`{count()}`:       "1:2: count: want 1 argument, got 0",
+	} {
+		t.Run(input, func(t *testing.T) {
+			tmpl, err := engine.Parse(input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			errObj, ok := tmpl.Execute(env).(*object.Error)
+			if !ok || errObj.Message != want {
+				t.Errorf("want error %q, got %#v", want, errObj)
+			}
+		})
+	}
+}
+
+func TestErrorLevel(t *testing.T) {
+	t.Parallel()
+
+	env := Must(NewEnvironment(
+		WithVariable("user", map[string]any{"name": "Alice", "address": nil}),
+		WithVariable("items", []string{"a"}),
+		WithVariable("title", ""),
+	))
+
+	tests := []struct {
+		input    string
+		want     string
+		warnings []string
+		strict   string // ErrorLevelStrict のエラー (空の場合は出力が want と同じ)
+	}{
+		{
+			input:    `[{$usre.name}]`,
+			want:     "[]",
+			warnings: []string{"1:4: undefined variable $usre"},
+			strict:   "1:4: undefined variable $usre",
+		},
+		{
+			input:    `{$user.name}{$user.nmae}`,
+			want:     "Alice",
+			warnings: []string{"1:20: undefined key nmae in $user"},
+			strict:   "1:20: undefined key nmae in $user",
+		},
+		{
+			input:    "{$items[0]}\n{$items[3]}",
+			want:     "a\n",
+			warnings: []string{"2:8: undefined index 3 in $items"},
+			strict:   "2:8: undefined index 3 in $items",
+		},
+		{
+			input:    `{$user.name|uper}`,
+			want:     "",
+			warnings: []string{"1:13: unknown modifier uper"},
+			strict:   "1:13: unknown modifier uper",
+		},
+		{
+			input:    `{$user.name.first}`,
+			want:     "",
+			warnings: []string{"1:13: cannot access field first of string value ($user.name)"},
+			strict:   "1:13: cannot access field first of string value ($user.name)",
+		},
+		// null-safe なアクセスや isset(), empty(), |default は報告しない
+		{input: `[{$user.address.city}]`, want: "[]"},
+		{input: `{isset($nobody.name)}/{empty($user.phone)}`, want: "false/true"},
+		{input: `{$nobody.name|default:"guest"}/{$title|default:"untitled"}/{$user.name|default:"x"}`, want: "guest/untitled/Alice"},
+		{input: `{$nobody|upper|default:"none"}`, want: "none"},
+		{input: `{if isset($nobody)}{$nobody}{else}-{/if}`, want: "-"},
+		{
+			input:    `{foreach from=$items item=v}{$v}{$v@nope}{$w}{/foreach}`,
+			want:     "a",
+			warnings: []string{"1:36: undefined loop property $v@nope", "1:44: undefined variable $w"},
+			strict:   "1:36: undefined loop property $v@nope",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			for _, level := range []ErrorLevel{ErrorLevelSilent, ErrorLevelWarn, ErrorLevelStrict} {
+				tmpl, err := New(WithErrorLevel(level)).Parse(tt.input)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				evaled, warnings := tmpl.ExecuteWithWarnings(context.Background(), env)
+				if level == ErrorLevelStrict && tt.strict != "" {
+					errObj, ok := evaled.(*object.Error)
+					if !ok {
+						t.Fatalf("strict: want object.Error, got %#v", evaled)
+					}
+					if errObj.Message != tt.strict {
+						t.Errorf("strict: got=%q, want=%q", errObj.Message, tt.strict)
+					}
+					continue
+				}
+
+				if got := evaled.Inspect(); got != tt.want {
+					t.Errorf("level %d: got=%q, want=%q", level, got, tt.want)
+				}
+				var got []string
+				for _, w := range warnings {
+					got = append(got, w.String())
+				}
+				var want []string
+				if level == ErrorLevelWarn {
+					want = tt.warnings
+				}
+				if !slices.Equal(got, want) {
+					t.Errorf("level %d: warnings got=%q, want=%q", level, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestValidation(t *testing.T) {
+	t.Parallel()
+
+	newEngine := func() *GoSmarty {
+		gsm := New(WithValidation(true))
+		if err := gsm.RegisterModifierFunc("truncate", func(s string, n int, etc string) string {
+			return s
+		}, 80, "..."); err != nil {
+			t.Fatal(err)
+		}
+		gsm.RegisterModifier("shout", func(input object.Object, args ...any) object.Object {
+			return input
+		})
+		gsm.Funcs(map[string]any{
+			"format_price": func(p int, currency string) string { return "" },
+			"concat":       func(parts ...string) string { return "" },
+		})
+		return gsm
+	}
+
+	tests := []struct {
+		input string
+		want  string // 空の場合はエラーにならない
+	}{
+		{input: `{$title|upper|escape:"html"}{$items|@implode:", "}{$d|date_format:"%Y":"-"}`},
+		{input: `{$title|truncate}{$title|truncate:20}{$title|truncate:20:"…"}{$title|shout:1:2:3}`},
+		{input: `{format_price($p, "JPY")}{concat()}{concat("a", "b", "c")}{if isset($a, $b) and empty($c)}{/if}`},
+		{input: `{$title|uper}`, want: "1:9: unknown modifier uper"},
+		{input: "{if $a}\n{foreach from=$items item=v}{$v|escpe}{/foreach}\n{/if}", want: "2:33: unknown modifier escpe"},
+		{input: `{$title|upper:1}`, want: "1:9: modifier upper: want 0 arguments, got 1"},
+		{input: `{$items|@in_array}`, want: "1:10: modifier in_array: want 1 to 2 arguments, got 0"},
+		{input: `{$title|truncate:1:"…":true}`, want: `1:9: modifier truncate: want 0 to 2 arguments, got 3`},
+		{input: `{format_prise($p)}`, want: "1:2: function format_prise is not defined"},
+		{input: `{format_price($p)}`, want: "1:2: format_price: want 2 arguments, got 1"},
+		{input: `{if isset()}{/if}`, want: "1:5: isset: want at least 1 arguments, got 0"},
+		{input: `{assign var="x" value=$y|lowr}{"Hello {$name|uppr}"}`, want: "1:26: unknown modifier lowr\n1:46: unknown modifier uppr"},
+		{input: `{$list[count($a, $b)]}`, want: "1:8: count: want 1 arguments, got 2"},
+		{input: "{\"a\nb {$x|nope} `$y|lower:1`\"}", want: "2:7: unknown modifier nope\n2:17: modifier lower: want 0 arguments, got 1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			_, err := newEngine().Parse(tt.input)
+			if tt.want == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("want error %q, got nil", tt.want)
+			}
+			if err.Error() != tt.want {
+				t.Errorf("got=%q, want=%q", err.Error(), tt.want)
+			}
+
+			// 検証しないエンジンではパースに成功する
+			if _, err := New().Parse(tt.input); err != nil {
+				t.Errorf("without validation: %s", err)
+			}
+		})
+	}
+}
@@ -20,27 +20,63 @@ type Lexer struct {
 	readPos int
 	ch      rune
 	state   lexerState
+
+	// 現在の文字 (ch) の位置
+	line   int
+	column int
+	// 読み取り中のトークンの先頭の位置
+	tokLine   int
+	tokColumn int
+	// 直前に返したトークンの種類
+	prevType token.TokenType
 }
 
 func New(input string) *Lexer {
 	l := &Lexer{
 		input: []rune(input),
 		state: stateText,
+		line:  1,
+	}
+	l.readChar()
+	return l
+}
+
+// NewAt は input の先頭がテンプレートの line 行 column 列にあるものとして位置を数える Lexer を作成します。
+// 文字列に埋め込まれた式をパースするときに使います。
+func NewAt(input string, line, column int) *Lexer {
+	l := &Lexer{
+		input:  []rune(input),
+		state:  stateText,
+		line:   line,
+		column: column - 1,
 	}
 	l.readChar()
 	return l
 }
 
 func (l *Lexer) NextToken() token.Token {
+	var tok token.Token
 	if l.state == stateText {
-		return l.nextTokenInText()
+		tok = l.nextTokenInText()
+	} else {
+		tok = l.nextTokenInTag()
 	}
-	return l.nextTokenInTag()
+	tok.Line = l.tokLine
+	tok.Column = l.tokColumn
+	l.prevType = tok.Type
+	return tok
+}
+
+// markToken は現在の文字をトークンの先頭として記録する
+func (l *Lexer) markToken() {
+	l.tokLine = l.line
+	l.tokColumn = l.column
 }
 
 // stateText時のトークン生成
 func (l *Lexer) nextTokenInText() token.Token {
 	var tok token.Token
+	l.markToken()
 	// `{` が見つかるか、入力が終わるまでを読む
 	pos := l.pos
 	for l.ch != '{' && l.ch != 0 {
@@ -69,6 +105,7 @@ func (l *Lexer) nextTokenInTag() token.Token {
 	var tok token.Token
 
 	l.skipWhitespace()
+	l.markToken()
 
 	switch l.ch {
 	case '{':
@@ -86,19 +123,32 @@ func (l *Lexer) nextTokenInTag() token.Token {
 		l.state = stateText // テキストモードに復帰
 	case '=':
 		if l.peekChar() == '=' {
-			ch := l.ch
 			l.readChar()
 			tok.Type = token.EQ
+			tok.Literal = "=="
+			if l.peekChar() == '=' {
+				l.readChar()
+				tok.Type = token.IDENTICAL
+				tok.Literal = "==="
+			}
+		} else if l.peekChar() == '>' {
+			ch := l.ch
+			l.readChar()
+			tok.Type = token.DARROW
 			tok.Literal = string(ch) + string(l.ch)
 		} else {
 			tok = newToken(token.ASSIGN, l.ch)
 		}
 	case '!':
 		if l.peekChar() == '=' {
-			ch := l.ch
 			l.readChar()
 			tok.Type = token.NOTEQ
-			tok.Literal = string(ch) + string(l.ch)
+			tok.Literal = "!="
+			if l.peekChar() == '=' {
+				l.readChar()
+				tok.Type = token.NOTIDENTICAL
+				tok.Literal = "!=="
+			}
 		} else {
 			tok = newToken(token.BANG, l.ch)
 		}
@@ -120,17 +170,46 @@ func (l *Lexer) nextTokenInTag() token.Token {
 		} else {
 			tok = newToken(token.LT, l.ch)
 		}
+	case '+':
+		tok = newToken(token.PLUS, l.ch)
+	case '-':
+		if l.peekChar() == '>' {
+			ch := l.ch
+			l.readChar()
+			tok.Type = token.ARROW
+			tok.Literal = string(ch) + string(l.ch)
+		} else {
+			tok = newToken(token.MINUS, l.ch)
+		}
 	case '$':
 		tok = newToken(token.DOLLAR, l.ch)
 	case '|':
 		tok = newToken(token.PIPE, l.ch)
+	case '@':
+		tok = newToken(token.AT, l.ch)
+	case ':':
+		tok = newToken(token.COLON, l.ch)
+	case ',':
+		tok = newToken(token.COMMA, l.ch)
 	case '.':
 		tok = newToken(token.DOT, l.ch)
 	case '[':
 		tok = newToken(token.LBRACKET, l.ch)
 	case ']':
 		tok = newToken(token.RBRACKET, l.ch)
-	case '"', '\'':
+	case '(':
+		tok = newToken(token.LPAREN, l.ch)
+	case ')':
+		tok = newToken(token.RPAREN, l.ch)
+	case '"':
+		// 変数やエスケープを含むダブルクォートの文字列は、パーサーが展開する
+		literal, interp := l.readDoubleQuoted()
+		tok.Type = token.STRING
+		if interp {
+			tok.Type = token.INTERP
+		}
+		tok.Literal = literal
+	case '\'':
 		tok.Type = token.STRING
 		tok.Literal = l.readString(l.ch)
 	case 0:
@@ -169,6 +248,11 @@ func (l *Lexer) readIdentifier() string {
 }
 
 func (l *Lexer) readChar() {
+	if l.ch == '\n' {
+		l.line++
+		l.column = 0
+	}
+	l.column++
 	if l.readPos >= len(l.input) {
 		l.ch = 0
 	} else {
@@ -190,6 +274,14 @@ func (l *Lexer) readNumber() string {
 	for unicode.IsDigit(l.ch) {
 		l.readChar()
 	}
+	// 小数部 (e.g., 0.5)
+	// {$arr.0.1} のように '.' に続く数字はインデックスなので、小数部として読まない
+	if l.ch == '.' && unicode.IsDigit(l.peekChar()) && l.prevType != token.DOT {
+		l.readChar()
+		for unicode.IsDigit(l.ch) {
+			l.readChar()
+		}
+	}
 	return string(l.input[pos:l.pos])
 }
 
@@ -204,6 +296,38 @@ func (l *Lexer) readString(quote rune) string {
 	return string(l.input[pos:l.pos])
 }
 
+// readDoubleQuoted はダブルクォートの文字列を読み、変数やエスケープを含むかどうかを返す
+// {$...} の中に書かれた引用符 (e.g., "{$a|default:"none"}") は文字列の終わりとして扱わない
+func (l *Lexer) readDoubleQuoted() (string, bool) {
+	pos := l.pos + 1
+	interp := false
+	depth := 0
+	for {
+		l.readChar()
+		switch {
+		case l.ch == 0:
+			return string(l.input[pos:l.pos]), interp
+		case l.ch == '\\':
+			interp = true
+			l.readChar() // エスケープされた文字を読み飛ばす
+			if l.ch == 0 {
+				return string(l.input[pos:l.pos]), interp
+			}
+		case l.ch == '$' || l.ch == '`':
+			interp = true
+		case l.ch == '{' && l.peekChar() == '$':
+			interp = true
+			depth++
+		case l.ch == '}' && depth > 0:
+			depth--
+		case (l.ch == '"' || l.ch == '\'') && depth > 0:
+			l.readString(l.ch)
+		case l.ch == '"':
+			return string(l.input[pos:l.pos]), interp
+		}
+	}
+}
+
 func (l *Lexer) readComment() string {
 	pos := l.pos + 1
 	for {
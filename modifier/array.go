@@ -0,0 +1,223 @@
+package modifier
+
+import (
+	"encoding/json"
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/szks-repo/gosmarty/object"
+)
+
+// 配列を対象とする組み込みの修飾子
+// 配列そのものに適用するため、通常は {$items|@count} のように '@' を付けて使います。
+var arrayBuiltins = map[string]Modifier{
+	"count": func(input object.Object, args ...any) object.Object {
+		switch v := input.(type) {
+		case object.Iterable:
+			// iter.Seq やチャネルは走査するまで要素の数が分からないため、{$item@total} と同じく NULL になる
+			if n, ok := v.(interface{ Len() int }); ok {
+				return object.NewInteger(n.Len())
+			}
+			return object.NULL
+		case object.ArrayLike:
+			return object.NewInteger(v.Len())
+		case object.MapLike:
+			return object.NewInteger(v.Len())
+		case *object.Null:
+			return object.NewInteger(0)
+		default:
+			return object.NewInteger(1)
+		}
+	},
+	"implode": implode,
+	"join":    implode,
+	"json_encode": func(input object.Object, args ...any) object.Object {
+		// OrderedMap のキーの順序と、小数の精度を保って出力する
+		v, err := object.JSONValue(input)
+		if err != nil {
+			return object.NewError("%s", err)
+		}
+		b, err := json.Marshal(v)
+		if err != nil {
+			return object.NewError("%s", err)
+		}
+		return object.NewString(string(b))
+	},
+	"array_keys": func(input object.Object, args ...any) object.Object {
+		switch v := input.(type) {
+		case object.ArrayLike:
+			keys := make([]object.Object, v.Len())
+			for i := range keys {
+				keys[i] = object.NewInteger(i)
+			}
+			return &object.Array{Value: keys}
+		case object.MapLike:
+			names := object.OrderedKeys(v)
+			keys := make([]object.Object, len(names))
+			for i, name := range names {
+				keys[i] = object.NewString(name)
+			}
+			return &object.Array{Value: keys}
+		default:
+			return object.NULL
+		}
+	},
+	"sort": func(input object.Object, args ...any) object.Object {
+		values, ok := elements(input)
+		if !ok {
+			return input
+		}
+		values = slices.Clone(values)
+		slices.SortStableFunc(values, compareElements)
+		return &object.Array{Value: values}
+	},
+	"reverse": func(input object.Object, args ...any) object.Object {
+		if s, ok := input.(*object.String); ok {
+			runes := []rune(s.Value)
+			slices.Reverse(runes)
+			return object.NewString(string(runes))
+		}
+		values, ok := elements(input)
+		if !ok {
+			return input
+		}
+		values = slices.Clone(values)
+		slices.Reverse(values)
+		return &object.Array{Value: values}
+	},
+	// {$items|@slice:1:2} は PHP の array_slice と同じく、負の値は末尾から数える
+	"slice": func(input object.Object, args ...any) object.Object {
+		offset, _ := intArg(args, 0)
+		length, hasLength := intArg(args, 1)
+
+		if s, ok := input.(*object.String); ok {
+			runes := []rune(s.Value)
+			start, end := sliceBounds(len(runes), offset, length, hasLength)
+			return object.NewString(string(runes[start:end]))
+		}
+		values, ok := elements(input)
+		if !ok {
+			return input
+		}
+		start, end := sliceBounds(len(values), offset, length, hasLength)
+		return &object.Array{Value: slices.Clone(values[start:end])}
+	},
+}
+
+// {$needle|in_array:$haystack} は == と同じ規則で比較し、
+// PHP と同じく {$needle|in_array:$haystack:true} では型と値が等しい要素だけを探す
+func inArray(rc *RenderContext, input object.Object, args ...object.Object) (object.Object, error) {
+	if len(args) == 0 {
+		return nil, errors.New("in_array requires an array argument")
+	}
+	haystack, ok := elements(args[0])
+	if !ok {
+		return object.NewBool(false), nil
+	}
+	equal := rc.Equal
+	if strict, ok := argAt(args, 1).(*object.Boolean); ok && strict.Value {
+		equal = rc.Identical
+	}
+	if equal == nil {
+		return nil, errors.New("in_array can only be used in a template")
+	}
+	return object.NewBool(slices.ContainsFunc(haystack, func(elem object.Object) bool {
+		return equal(elem, input)
+	})), nil
+}
+
+func argAt(args []object.Object, i int) object.Object {
+	if i >= len(args) {
+		return nil
+	}
+	return args[i]
+}
+
+// {$tags|@implode:", "}
+func implode(input object.Object, args ...any) object.Object {
+	sep := ""
+	if len(args) > 0 {
+		if s, ok := args[0].(object.Object); ok {
+			sep = s.Inspect()
+		}
+	}
+	values, ok := elements(input)
+	if !ok {
+		return object.NewString(input.Inspect())
+	}
+	parts := make([]string, len(values))
+	for i, v := range values {
+		if v.Type() != object.NullType {
+			parts[i] = v.Inspect()
+		}
+	}
+	return object.NewString(strings.Join(parts, sep))
+}
+
+// elements は配列の要素、またはマップの値をキーの順に返します。
+func elements(v any) ([]object.Object, bool) {
+	switch v := v.(type) {
+	case *object.Array:
+		return v.Value, true
+	case object.ArrayLike:
+		values := make([]object.Object, v.Len())
+		for i := range values {
+			values[i], _ = v.At(i)
+		}
+		return values, true
+	case object.MapLike:
+		keys := object.OrderedKeys(v)
+		values := make([]object.Object, len(keys))
+		for i, key := range keys {
+			values[i], _ = v.Get(key)
+		}
+		return values, true
+	default:
+		return nil, false
+	}
+}
+
+// compareElements は数値どうしは数値として、それ以外は文字列として比較します。
+func compareElements(a, b object.Object) int {
+	if order, ok := object.CompareNumbers(a, b); ok {
+		return order
+	}
+	return strings.Compare(a.Inspect(), b.Inspect())
+}
+
+func intArg(args []any, i int) (int, bool) {
+	if i >= len(args) {
+		return 0, false
+	}
+	switch v := args[i].(type) {
+	case *object.String:
+		n, err := strconv.Atoi(v.Value)
+		return n, err == nil
+	case object.Object:
+		return object.ToInt(v)
+	default:
+		return 0, false
+	}
+}
+
+// sliceBounds は array_slice と同じ規則で offset と length から範囲を求めます。
+func sliceBounds(n, offset, length int, hasLength bool) (int, int) {
+	start := offset
+	if start < 0 {
+		start = max(n+start, 0)
+	}
+	start = min(start, n)
+
+	end := n
+	if hasLength {
+		if length < 0 {
+			end = n + length
+		} else {
+			end = start + length
+		}
+	}
+	end = max(min(end, n), start)
+	return start, end
+}
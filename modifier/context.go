@@ -0,0 +1,84 @@
+package modifier
+
+import (
+	"context"
+	"time"
+
+	"github.com/szks-repo/gosmarty/object"
+)
+
+// DefaultCharset はエンジンで文字コードが指定されていない場合の文字コードです。
+const DefaultCharset = "UTF-8"
+
+// RenderContext はテンプレートを実行中のエンジンの設定と変数を修飾子に渡します。
+type RenderContext struct {
+	// Context は Template.ExecuteContext に渡された context.Context です
+	Context context.Context
+	// Location は日付の整形に使うタイムゾーンです
+	Location *time.Location
+	// Locale は月名や曜日名に使うロケールです (e.g., "en", "ja_JP")
+	Locale string
+	// Charset は出力の文字コードです
+	Charset string
+	// EscapeHTML は出力が既定でHTMLエスケープされる (WithEscapeHTML, WithContextualEscaping) 場合に true です
+	EscapeHTML bool
+	// GetVar は実行中のテンプレートから見える変数を探します
+	GetVar func(name string) (object.Object, bool)
+	// Equal は実行中のエンジンの比較の規則 (gosmarty.WithComparison) で、== と同じく2つの値を比較します
+	Equal func(a, b object.Object) bool
+	// Identical は === と同じく、型と値が等しいかを返します
+	Identical func(a, b object.Object) bool
+}
+
+// Background はエンジンに紐付かない既定の RenderContext を返します。
+// 比較の規則はエンジンが決めるため、Equal と Identical は nil です。
+func Background() *RenderContext {
+	return &RenderContext{
+		Context:  context.Background(),
+		Location: time.Local,
+		Charset:  DefaultCharset,
+		GetVar: func(string) (object.Object, bool) {
+			return nil, false
+		},
+	}
+}
+
+// ContextModifier は失敗することができ、実行中の RenderContext を参照できる修飾子です。
+// Modifier と同じ Registry に登録して使えます。
+// 返した error は、テンプレート上の位置を含む実行時エラーになります。
+type ContextModifier func(rc *RenderContext, input object.Object, args ...object.Object) (object.Object, error)
+
+// withContext は Modifier を ContextModifier として呼び出せるようにします。
+// Modifier が object.Error を返した場合は error として扱います。
+func (mod Modifier) withContext() ContextModifier {
+	return func(_ *RenderContext, input object.Object, args ...object.Object) (object.Object, error) {
+		anyArgs := make([]any, len(args))
+		for i, arg := range args {
+			anyArgs[i] = arg
+		}
+		result := mod(input, anyArgs...)
+		if errObj, ok := result.(*object.Error); ok {
+			return nil, errObj
+		}
+		return result, nil
+	}
+}
+
+// withoutContext は ContextModifier を Background の RenderContext で呼び出す Modifier にします。
+func (mod ContextModifier) withoutContext() Modifier {
+	return func(input object.Object, args ...any) object.Object {
+		objArgs := make([]object.Object, len(args))
+		for i, arg := range args {
+			obj, ok := arg.(object.Object)
+			if !ok {
+				return object.NewError("argument %d: unexpected %T", i+1, arg)
+			}
+			objArgs[i] = obj
+		}
+		result, err := mod(Background(), input, objArgs...)
+		if err != nil {
+			return object.NewError("%s", err)
+		}
+		return result
+	}
+}
@@ -0,0 +1,117 @@
+package modifier
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/szks-repo/gosmarty/object"
+)
+
+// Smarty の date_format の既定の書式
+const defaultDateFormat = "%b %e, %Y"
+
+// 文字列の日付として受け付けるレイアウト
+var dateLayouts = []string{
+	time.RFC3339Nano,
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	"2006/01/02 15:04:05",
+	"2006/01/02 15:04",
+	"2006/01/02",
+	time.RFC1123Z,
+	time.RFC1123,
+}
+
+// DateFormat は指定したタイムゾーンとロケールで日付を整形する date_format 修飾子を返します。
+//
+//	{$t|date_format}                          // "%b %e, %Y"
+//	{$t|date_format:"%Y/%m/%d %H:%M"}
+//	{$t|date_format:"%Y/%m/%d":"2024-01-01"}  // 入力が空なら既定の日付を使う
+//
+// 入力には object.Time、UNIXタイムスタンプの数値、日付として解釈できる文字列を受け付けます。
+func DateFormat(loc *time.Location, locale string) Modifier {
+	if loc == nil {
+		loc = time.Local
+	}
+	names := lookupDateNames(locale)
+
+	return func(input object.Object, args ...any) object.Object {
+		format := defaultDateFormat
+		if len(args) > 0 {
+			if s, ok := args[0].(*object.String); ok && s.Value != "" {
+				format = s.Value
+			}
+		}
+
+		t, ok := toTime(input, loc)
+		if !ok && len(args) > 1 {
+			if def, isObj := args[1].(object.Object); isObj {
+				t, ok = toTime(def, loc)
+			}
+		}
+		if !ok {
+			return object.NULL
+		}
+
+		return object.NewString(strftime(t.In(loc), format, names))
+	}
+}
+
+// dateFormat は実行中のエンジンのタイムゾーンとロケールで日付を整形する、組み込みの date_format 修飾子です。
+func dateFormat(rc *RenderContext, input object.Object, args ...object.Object) (object.Object, error) {
+	return DateFormat(rc.Location, rc.Locale).withContext()(rc, input, args...)
+}
+
+// toTime は date_format の入力を time.Time に変換します。
+// 空の値や解釈できない値の場合は false を返します。
+func toTime(input object.Object, loc *time.Location) (time.Time, bool) {
+	switch v := input.(type) {
+	case *object.Time:
+		if v.Value.IsZero() {
+			return time.Time{}, false
+		}
+		return v.Value, true
+	case *object.Integer:
+		if !v.Value.IsInt64() {
+			return time.Time{}, false
+		}
+		return time.Unix(v.Value.Int64(), 0), true
+	case *object.Decimal:
+		f, _ := v.Value.Float64()
+		sec, frac := math.Modf(f)
+		return time.Unix(int64(sec), int64(frac*1e9)), true
+	case *object.Number:
+		sec, frac := math.Modf(v.Value)
+		return time.Unix(int64(sec), int64(frac*1e9)), true
+	case *object.String:
+		return parseTime(v.Value, loc)
+	case *object.Optional:
+		if !v.Some() {
+			return time.Time{}, false
+		}
+		return toTime(v.Unwrap(), loc)
+	default:
+		return time.Time{}, false
+	}
+}
+
+func parseTime(s string, loc *time.Location) (time.Time, bool) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return time.Time{}, false
+	}
+	if ts, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(ts, 0), true
+	}
+	for _, layout := range dateLayouts {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
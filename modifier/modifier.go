@@ -1,14 +1,17 @@
 package modifier
 
 import (
+	"html"
+	"net/url"
 	"strings"
-	"sync"
 
 	phpstring "github.com/szks-repo/go-php-functions/string"
 
 	"github.com/szks-repo/gosmarty/object"
 )
 
+// Modifier は変数修飾子です。
+// args には ':' で区切られた引数を評価した object.Object が順に渡されます。
 type Modifier func(input object.Object, args ...any) object.Object
 
 // Builtin variable modifiers
@@ -26,6 +29,7 @@ type Modifier func(input object.Object, args ...any) object.Object
 // - indent
 // - lower
 // - nl2br
+// - raw (gosmarty拡張: 自動エスケープを無効にする)
 // - regex_replace
 // - replace
 // - spacify
@@ -37,22 +41,69 @@ type Modifier func(input object.Object, args ...any) object.Object
 // - unescape
 // - upper
 // - wordwrap
-var registry = map[string]Modifier{
-	"nl2br": func(input object.Object, args ...any) object.Object {
-		if input.Type() != object.StringType {
-			return object.NULL // またはエラーオブジェクト
+//
+// 配列を対象とする修飾子 ({$items|@count} のように '@' を付けて使う)
+// - count
+// - implode / join
+// - json_encode
+// - array_keys
+// - in_array
+// - sort
+// - reverse
+// - slice
+var builtins = map[string]Modifier{
+	"escape": func(input object.Object, args ...any) object.Object {
+		if input.Type() == object.HTMLType {
+			return input
+		}
+		if input.Type() != object.StringType && !object.IsNumeric(input) {
+			return object.NULL
 		}
 
-		return object.NewString(phpstring.Nl2br(input.Inspect()))
+		escType := "html"
+		if len(args) > 0 {
+			if s, ok := args[0].(*object.String); ok {
+				escType = s.Value
+			}
+		}
+		switch escType {
+		case "html":
+			return object.NewHTML(html.EscapeString(input.Inspect()))
+		case "url":
+			return object.NewHTML(url.QueryEscape(input.Inspect()))
+		default:
+			return object.NULL
+		}
+	},
+	// {$title|default:"no title"} は値が NULL か空文字列の場合に引数の値を返す
+	"default": func(input object.Object, args ...any) object.Object {
+		switch input.Type() {
+		case object.NullType:
+		case object.StringType, object.HTMLType:
+			if input.Inspect() != "" {
+				return input
+			}
+		default:
+			return input
+		}
+		if len(args) > 0 {
+			if def, ok := args[0].(object.Object); ok {
+				return def
+			}
+		}
+		return object.NewString("")
 	},
-	"number_format": func(input object.Object, args ...any) object.Object {
-		if input.Type() != object.NumberType {
+	"raw": func(input object.Object, args ...any) object.Object {
+		switch input.Type() {
+		case object.HTMLType:
+			return input
+		case object.NullType:
 			return object.NULL
+		default:
+			return object.NewHTML(input.Inspect())
 		}
-
-		val := input.(*object.Number).Value
-		return object.NewString(phpstring.NumberFormat[float64](val))
 	},
+	"number_format": numberFormat,
 	"upper": func(input object.Object, args ...any) object.Object {
 		if input.Type() != object.StringType {
 			return object.NULL
@@ -83,20 +134,100 @@ var registry = map[string]Modifier{
 	},
 }
 
-var registryMu sync.RWMutex
+// contextBuiltins は実行中の RenderContext を参照する組み込みの修飾子です。
+var contextBuiltins = map[string]ContextModifier{
+	"nl2br":       nl2br,
+	"date_format": dateFormat,
+	"in_array":    inArray,
+}
+
+// nl2br は改行の前に <br /> を挿入する
+// 出力をHTMLエスケープする場合は、改行以外をエスケープしてから挿入した安全な文字列を返す
+func nl2br(rc *RenderContext, input object.Object, args ...object.Object) (object.Object, error) {
+	switch input.Type() {
+	case object.HTMLType:
+		return object.NewHTML(phpstring.Nl2br(input.Inspect())), nil
+	case object.StringType:
+		if rc.EscapeHTML {
+			return object.NewHTML(phpstring.Nl2br(html.EscapeString(input.Inspect()))), nil
+		}
+		return object.NewString(phpstring.Nl2br(input.Inspect())), nil
+	default:
+		return object.NULL, nil
+	}
+}
+
+// builtinArity は組み込みの修飾子が受け取る引数の数です。
+var builtinArity = map[string]Arity{
+	"nl2br":         {Max: 0},
+	"escape":        {Max: 1},
+	"default":       {Max: 1},
+	"raw":           {Max: 0},
+	"number_format": {Max: 3},
+	"date_format":   {Max: 2},
+	"upper":         {Max: 0},
+	"lower":         {Max: 0},
+	"wordwrap":      {Max: 3},
+	"count":         {Max: 0},
+	"implode":       {Max: 1},
+	"join":          {Max: 1},
+	"json_encode":   {Max: 0},
+	"array_keys":    {Max: 0},
+	"in_array":      {Min: 1, Max: 2},
+	"sort":          {Max: 0},
+	"reverse":       {Max: 0},
+	"slice":         {Max: 2},
+}
+
+// builtinRegistry は組み込みの修飾子だけを持つ Registry です。
+var builtinRegistry = newBuiltinRegistry()
+
+func newBuiltinRegistry() *Registry {
+	reg := NewRegistry(nil)
+	for name, mod := range builtins {
+		reg.mods[name] = builtinEntry(name, entry{mod: mod})
+	}
+	for name, mod := range arrayBuiltins {
+		reg.mods[name] = builtinEntry(name, entry{mod: mod})
+	}
+	for name, mod := range contextBuiltins {
+		reg.mods[name] = builtinEntry(name, entry{ctxMod: mod})
+	}
+	return reg
+}
+
+// builtinEntry は e に組み込みの修飾子の引数の数を設定します。
+func builtinEntry(name string, e entry) entry {
+	if arity, ok := builtinArity[name]; ok {
+		e.arity = &arity
+	}
+	return e
+}
+
+// defaultRegistry はパッケージ全体で共有される Registry です。
+// Register で登録した修飾子は、この Registry を親に持つすべてのエンジンから参照されます。
+var defaultRegistry = NewRegistry(builtinRegistry)
+
+// Builtins は組み込みの修飾子だけを持つ Registry を返します。
+// 返される Registry に登録することはできません。
+func Builtins() *Registry {
+	return builtinRegistry
+}
+
+// Default はパッケージ全体で共有される Registry を返します。
+func Default() *Registry {
+	return defaultRegistry
+}
 
 func Get(name string) (Modifier, bool) {
-	registryMu.RLock()
-	defer registryMu.RUnlock()
-	mod, ok := registry[name]
-	return mod, ok
+	return defaultRegistry.Get(name)
 }
 
 func Register(name string, mod Modifier) bool {
-	registryMu.Lock()
-	defer registryMu.Unlock()
-	_, overrided := registry[name]
-	registry[name] = mod
+	return defaultRegistry.Register(name, mod)
+}
 
-	return overrided
+// RegisterContext は ContextModifier をパッケージ全体で共有される Registry に登録します。
+func RegisterContext(name string, mod ContextModifier) bool {
+	return defaultRegistry.RegisterContext(name, mod)
 }
@@ -0,0 +1,93 @@
+package modifier
+
+import (
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/szks-repo/gosmarty/object"
+)
+
+// numberFormat は PHP の number_format と同じく、数値を桁区切りして整形します。
+//
+//	{$price|number_format}           // 1,235
+//	{$price|number_format:2}         // 1,234.57
+//	{$price|number_format:2:",":"."} // 1.234,57
+//
+// Integer と Decimal は float64 を経由せずに正確に整形します。
+// 丸めは PHP と同じく0から遠い方向に行います。
+func numberFormat(input object.Object, args ...any) object.Object {
+	r, ok := toRatForFormat(input)
+	if !ok {
+		return object.NULL
+	}
+
+	decimals, _ := intArg(args, 0)
+	decimals = max(decimals, 0)
+	decPoint := stringArg(args, 1, ".")
+	thousandsSep := stringArg(args, 2, ",")
+
+	// 10^decimals 倍して0から遠い方向に丸める
+	scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals)), nil)
+	num := new(big.Int).Mul(new(big.Int).Abs(r.Num()), scale)
+	q, m := new(big.Int).QuoRem(num, r.Denom(), new(big.Int))
+	if m.Lsh(m, 1).Cmp(r.Denom()) >= 0 {
+		q.Add(q, big.NewInt(1))
+	}
+
+	digits := q.String()
+	if len(digits) <= decimals {
+		digits = strings.Repeat("0", decimals-len(digits)+1) + digits
+	}
+	intPart, fracPart := digits[:len(digits)-decimals], digits[len(digits)-decimals:]
+
+	var out strings.Builder
+	if r.Sign() < 0 && q.Sign() != 0 {
+		out.WriteString("-")
+	}
+	for i := range len(intPart) {
+		if i > 0 && (len(intPart)-i)%3 == 0 {
+			out.WriteString(thousandsSep)
+		}
+		out.WriteByte(intPart[i])
+	}
+	if decimals > 0 {
+		out.WriteString(decPoint)
+		out.WriteString(fracPart)
+	}
+	return object.NewString(out.String())
+}
+
+func toRatForFormat(input object.Object) (*big.Rat, bool) {
+	switch v := input.(type) {
+	case *object.Number:
+		if math.IsInf(v.Value, 0) || math.IsNaN(v.Value) {
+			return nil, false
+		}
+		// 2進数の誤差で 1.005 が 1.00 に丸められないよう、最短の10進表現を使う
+		d, ok := object.ParseDecimal(strconv.FormatFloat(v.Value, 'f', -1, 64))
+		if !ok {
+			return nil, false
+		}
+		return d.Value, true
+	case *object.String:
+		d, ok := object.ParseDecimal(strings.TrimSpace(v.Value))
+		if !ok {
+			return nil, false
+		}
+		return d.Value, true
+	default:
+		return object.ToRat(input)
+	}
+}
+
+func stringArg(args []any, i int, def string) string {
+	if i >= len(args) {
+		return def
+	}
+	if obj, ok := args[i].(object.Object); ok && obj.Type() != object.NullType {
+		return obj.Inspect()
+	}
+	return def
+}
@@ -0,0 +1,135 @@
+package modifier
+
+import (
+	"errors"
+	"sync"
+)
+
+// Registry は名前から修飾子を引く表です。
+// 親を持つ Registry は、自身に登録されていない修飾子を親から探すため、
+// 組み込みの修飾子を引き継ぎつつ、エンジンごとに修飾子を追加・上書きできます。
+type Registry struct {
+	mu     sync.RWMutex
+	parent *Registry
+	mods   map[string]entry
+}
+
+// entry は Modifier か ContextModifier のどちらか一方を持ちます。
+type entry struct {
+	mod    Modifier
+	ctxMod ContextModifier
+	// 引数の数を宣言していない修飾子は nil
+	arity *Arity
+}
+
+// Arity は修飾子が受け取る ':' 区切りの引数の数です。
+// Max が負の場合、引数の数に上限はありません。
+type Arity struct {
+	Min int
+	Max int
+}
+
+// Check は n 個の引数で修飾子を呼び出せない場合にエラーを返します。
+func (a Arity) Check(n int) error {
+	if n < a.Min || (a.Max >= 0 && n > a.Max) {
+		return errors.New(arityMessage(a.Min, a.Max, a.Max < 0, n))
+	}
+	return nil
+}
+
+// NewRegistry は parent を引き継ぐ空の Registry を作成します。
+// parent が nil の場合は何も引き継ぎません。
+func NewRegistry(parent *Registry) *Registry {
+	return &Registry{
+		parent: parent,
+		mods:   make(map[string]entry),
+	}
+}
+
+func (r *Registry) lookup(name string) (entry, bool) {
+	for reg := r; reg != nil; reg = reg.parent {
+		reg.mu.RLock()
+		e, ok := reg.mods[name]
+		reg.mu.RUnlock()
+		if ok {
+			return e, true
+		}
+	}
+	return entry{}, false
+}
+
+// Get は name の修飾子を、自身、親の順に探します。
+// ContextModifier は Background の RenderContext で呼び出す Modifier として返します。
+func (r *Registry) Get(name string) (Modifier, bool) {
+	e, ok := r.lookup(name)
+	if !ok {
+		return nil, false
+	}
+	if e.ctxMod != nil {
+		return e.ctxMod.withoutContext(), true
+	}
+	return e.mod, true
+}
+
+// GetContext は name の修飾子を、自身、親の順に探して ContextModifier として返します。
+// Modifier が object.Error を返した場合は error として扱います。
+func (r *Registry) GetContext(name string) (ContextModifier, bool) {
+	e, ok := r.lookup(name)
+	if !ok {
+		return nil, false
+	}
+	if e.ctxMod != nil {
+		return e.ctxMod, true
+	}
+	return e.mod.withContext(), true
+}
+
+// Arity は name の修飾子が宣言した引数の数を返します。
+// 修飾子が見つからないか、引数の数を宣言していない場合は false を返します。
+func (r *Registry) Arity(name string) (Arity, bool) {
+	e, ok := r.lookup(name)
+	if !ok || e.arity == nil {
+		return Arity{}, false
+	}
+	return *e.arity, true
+}
+
+// Register は修飾子を登録し、同じ名前の修飾子を上書きした場合に true を返します。
+// 親の修飾子は変更されず、この Registry の中でのみ上書きされます。
+func (r *Registry) Register(name string, mod Modifier) bool {
+	return r.register(name, entry{mod: mod})
+}
+
+// RegisterContext は ContextModifier を登録し、同じ名前の修飾子を上書きした場合に true を返します。
+func (r *Registry) RegisterContext(name string, mod ContextModifier) bool {
+	return r.register(name, entry{ctxMod: mod})
+}
+
+// RegisterWithArity は引数の数を宣言して修飾子を登録し、同じ名前の修飾子を上書きした場合に true を返します。
+// 宣言した引数の数は、パース時の検証 (gosmarty.WithValidation) に使われます。
+func (r *Registry) RegisterWithArity(name string, mod Modifier, arity Arity) bool {
+	return r.register(name, entry{mod: mod, arity: &arity})
+}
+
+// RegisterFunc は Func と同様に通常のGoの関数から修飾子を作成し、関数の型から求めた引数の数とともに登録します。
+func (r *Registry) RegisterFunc(name string, fn any, defaults ...any) error {
+	mod, arity, err := newFunc(fn, defaults...)
+	if err != nil {
+		return err
+	}
+	r.register(name, entry{mod: mod, arity: &arity})
+	return nil
+}
+
+func (r *Registry) register(name string, e entry) bool {
+	if r == builtinRegistry {
+		panic("modifier: cannot register to the builtin registry")
+	}
+	_, overrided := r.lookup(name)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.mods[name] = e
+
+	return overrided
+}
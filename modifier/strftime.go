@@ -0,0 +1,192 @@
+package modifier
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// dateNames はロケールごとの月名・曜日名と既定の書式を保持します。
+type dateNames struct {
+	days        [7]string
+	shortDays   [7]string
+	months      [12]string
+	shortMonths [12]string
+	am, pm      string
+	dateTime    string // %c
+	date        string // %x
+	time        string // %X
+}
+
+var defaultDateNames = &dateNames{
+	days:        [7]string{"Sunday", "Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday"},
+	shortDays:   [7]string{"Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat"},
+	months:      [12]string{"January", "February", "March", "April", "May", "June", "July", "August", "September", "October", "November", "December"},
+	shortMonths: [12]string{"Jan", "Feb", "Mar", "Apr", "May", "Jun", "Jul", "Aug", "Sep", "Oct", "Nov", "Dec"},
+	am:          "AM",
+	pm:          "PM",
+	dateTime:    "%a %b %e %H:%M:%S %Y",
+	date:        "%m/%d/%y",
+	time:        "%H:%M:%S",
+}
+
+var localeDateNames = map[string]*dateNames{
+	"en": defaultDateNames,
+	"ja": {
+		days:        [7]string{"日曜日", "月曜日", "火曜日", "水曜日", "木曜日", "金曜日", "土曜日"},
+		shortDays:   [7]string{"日", "月", "火", "水", "木", "金", "土"},
+		months:      [12]string{"1月", "2月", "3月", "4月", "5月", "6月", "7月", "8月", "9月", "10月", "11月", "12月"},
+		shortMonths: [12]string{"1月", "2月", "3月", "4月", "5月", "6月", "7月", "8月", "9月", "10月", "11月", "12月"},
+		am:          "午前",
+		pm:          "午後",
+		dateTime:    "%Y年%m月%d日 %H時%M分%S秒",
+		date:        "%Y年%m月%d日",
+		time:        "%H時%M分%S秒",
+	},
+}
+
+// lookupDateNames は "ja_JP.UTF-8" や "en-US" のようなロケール名から言語部分を取り出して探します。
+// 未知のロケールの場合は英語の名前を返します。
+func lookupDateNames(locale string) *dateNames {
+	lang := strings.ToLower(locale)
+	if i := strings.IndexAny(lang, "_-."); i >= 0 {
+		lang = lang[:i]
+	}
+	if names, ok := localeDateNames[lang]; ok {
+		return names
+	}
+	return defaultDateNames
+}
+
+// Strftime は PHP の strftime と同じ変換指定子で時刻を整形します。
+// 未知の変換指定子はそのまま出力されます。
+func Strftime(t time.Time, format, locale string) string {
+	return strftime(t, format, lookupDateNames(locale))
+}
+
+func strftime(t time.Time, format string, names *dateNames) string {
+	var out strings.Builder
+	runes := []rune(format)
+	for i := 0; i < len(runes); i++ {
+		if runes[i] != '%' || i+1 >= len(runes) {
+			out.WriteRune(runes[i])
+			continue
+		}
+		i++
+		switch runes[i] {
+		// 日
+		case 'a':
+			out.WriteString(names.shortDays[t.Weekday()])
+		case 'A':
+			out.WriteString(names.days[t.Weekday()])
+		case 'd':
+			fmt.Fprintf(&out, "%02d", t.Day())
+		case 'e':
+			fmt.Fprintf(&out, "%2d", t.Day())
+		case 'j':
+			fmt.Fprintf(&out, "%03d", t.YearDay())
+		case 'u':
+			wd := int(t.Weekday())
+			if wd == 0 {
+				wd = 7
+			}
+			fmt.Fprintf(&out, "%d", wd)
+		case 'w':
+			fmt.Fprintf(&out, "%d", t.Weekday())
+		// 週
+		case 'U':
+			fmt.Fprintf(&out, "%02d", (t.YearDay()+6-int(t.Weekday()))/7)
+		case 'V':
+			_, week := t.ISOWeek()
+			fmt.Fprintf(&out, "%02d", week)
+		case 'W':
+			fmt.Fprintf(&out, "%02d", (t.YearDay()+6-(int(t.Weekday())+6)%7)/7)
+		// 月
+		case 'b', 'h':
+			out.WriteString(names.shortMonths[t.Month()-1])
+		case 'B':
+			out.WriteString(names.months[t.Month()-1])
+		case 'm':
+			fmt.Fprintf(&out, "%02d", int(t.Month()))
+		// 年
+		case 'C':
+			fmt.Fprintf(&out, "%02d", t.Year()/100)
+		case 'g':
+			year, _ := t.ISOWeek()
+			fmt.Fprintf(&out, "%02d", year%100)
+		case 'G':
+			year, _ := t.ISOWeek()
+			fmt.Fprintf(&out, "%d", year)
+		case 'y':
+			fmt.Fprintf(&out, "%02d", t.Year()%100)
+		case 'Y':
+			fmt.Fprintf(&out, "%d", t.Year())
+		// 時刻
+		case 'H':
+			fmt.Fprintf(&out, "%02d", t.Hour())
+		case 'k':
+			fmt.Fprintf(&out, "%2d", t.Hour())
+		case 'I':
+			fmt.Fprintf(&out, "%02d", hour12(t))
+		case 'l':
+			fmt.Fprintf(&out, "%2d", hour12(t))
+		case 'M':
+			fmt.Fprintf(&out, "%02d", t.Minute())
+		case 'p':
+			out.WriteString(meridiem(t, names))
+		case 'P':
+			out.WriteString(strings.ToLower(meridiem(t, names)))
+		case 'r':
+			out.WriteString(strftime(t, "%I:%M:%S %p", names))
+		case 'R':
+			out.WriteString(strftime(t, "%H:%M", names))
+		case 'S':
+			fmt.Fprintf(&out, "%02d", t.Second())
+		case 'T':
+			out.WriteString(strftime(t, "%H:%M:%S", names))
+		case 'X':
+			out.WriteString(strftime(t, names.time, names))
+		case 'z':
+			out.WriteString(t.Format("-0700"))
+		case 'Z':
+			out.WriteString(t.Format("MST"))
+		// 日付と時刻
+		case 'c':
+			out.WriteString(strftime(t, names.dateTime, names))
+		case 'D':
+			out.WriteString(strftime(t, "%m/%d/%y", names))
+		case 'F':
+			out.WriteString(strftime(t, "%Y-%m-%d", names))
+		case 's':
+			fmt.Fprintf(&out, "%d", t.Unix())
+		case 'x':
+			out.WriteString(strftime(t, names.date, names))
+		// その他
+		case 'n':
+			out.WriteByte('\n')
+		case 't':
+			out.WriteByte('\t')
+		case '%':
+			out.WriteByte('%')
+		default:
+			out.WriteRune('%')
+			out.WriteRune(runes[i])
+		}
+	}
+	return out.String()
+}
+
+func hour12(t time.Time) int {
+	h := t.Hour() % 12
+	if h == 0 {
+		return 12
+	}
+	return h
+}
+
+func meridiem(t time.Time, names *dateNames) string {
+	if t.Hour() < 12 {
+		return names.am
+	}
+	return names.pm
+}
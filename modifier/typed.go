@@ -0,0 +1,162 @@
+package modifier
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/szks-repo/gosmarty/object"
+)
+
+var errorInterface = reflect.TypeFor[error]()
+
+// Func は通常のGoの関数から修飾子を作成します。
+//
+// 関数の最初の引数には修飾子の入力が、続く引数には ':' で区切られた引数が渡されます。
+// 入力と引数は object.Object から関数の引数の型に変換されます。
+//
+//	truncate, err := modifier.Func(func(s string, length int, etc string) string {
+//		...
+//	}, 80, "...")
+//
+// defaults は末尾の引数の既定値で、テンプレートで省略された引数に使われます。
+// 上の例では {$title|truncate} は length=80, etc="..." で、{$title|truncate:20} は etc="..." で呼び出されます。
+// 既定値のない引数は省略できません。可変長引数は常に省略できます。
+//
+// 関数の戻り値は1つか、2つ目が error である必要があります。
+// 引数の数や型が合わない場合や関数が error を返した場合、修飾子は object.Error を返します。
+func Func(fn any, defaults ...any) (Modifier, error) {
+	mod, _, err := newFunc(fn, defaults...)
+	return mod, err
+}
+
+// newFunc は Func で作成した修飾子と、その修飾子が受け取る引数の数を返します。
+func newFunc(fn any, defaults ...any) (Modifier, Arity, error) {
+	fv := reflect.ValueOf(fn)
+	if fv.Kind() != reflect.Func {
+		return nil, Arity{}, fmt.Errorf("modifier: %T is not a function", fn)
+	}
+	ft := fv.Type()
+	if ft.NumIn() == 0 {
+		return nil, Arity{}, fmt.Errorf("modifier: %s must take the input as its first argument", ft)
+	}
+	switch {
+	case ft.NumOut() == 1 && ft.Out(0) != errorInterface:
+	case ft.NumOut() == 2 && ft.Out(1) == errorInterface:
+	default:
+		return nil, Arity{}, fmt.Errorf("modifier: %s must return a single value or a value and an error", ft)
+	}
+
+	// 入力と可変長引数を除いた、':' で渡される引数の型
+	params := make([]reflect.Type, 0, ft.NumIn()-1)
+	for i := 1; i < ft.NumIn(); i++ {
+		if ft.IsVariadic() && i == ft.NumIn()-1 {
+			break
+		}
+		params = append(params, ft.In(i))
+	}
+	if len(defaults) > len(params) {
+		return nil, Arity{}, fmt.Errorf("modifier: %s takes %d arguments, got %d defaults", ft, len(params), len(defaults))
+	}
+
+	// 既定値は末尾の引数に対応させる
+	required := len(params) - len(defaults)
+	defaultValues := make([]reflect.Value, len(defaults))
+	for i, d := range defaults {
+		t := params[required+i]
+		v, err := defaultValue(d, t)
+		if err != nil {
+			return nil, Arity{}, fmt.Errorf("modifier: default for argument %d: %w", required+i+1, err)
+		}
+		defaultValues[i] = v
+	}
+	arity := Arity{Min: required, Max: len(params)}
+	if ft.IsVariadic() {
+		arity.Max = -1
+	}
+
+	return func(input object.Object, args ...any) object.Object {
+		if err := arity.Check(len(args)); err != nil {
+			return object.NewError("%s", err)
+		}
+
+		in := make([]reflect.Value, 0, ft.NumIn())
+		v, err := object.ToGo(input, ft.In(0))
+		if err != nil {
+			return object.NewError("input: %s", err)
+		}
+		in = append(in, v)
+
+		for i, arg := range args {
+			var t reflect.Type
+			if i < len(params) {
+				t = params[i]
+			} else {
+				t = ft.In(ft.NumIn() - 1).Elem()
+			}
+			obj, ok := arg.(object.Object)
+			if !ok {
+				return object.NewError("argument %d: unexpected %T", i+1, arg)
+			}
+			v, err := object.ToGo(obj, t)
+			if err != nil {
+				return object.NewError("argument %d: %s", i+1, err)
+			}
+			in = append(in, v)
+		}
+		// 省略された引数には既定値を使う
+		for i := len(args); i < len(params); i++ {
+			in = append(in, defaultValues[i-required])
+		}
+
+		out := fv.Call(in)
+		if len(out) == 2 {
+			if err, _ := out[1].Interface().(error); err != nil {
+				return object.NewError("%s", err)
+			}
+		}
+		result, err := object.Wrap(out[0].Interface())
+		if err != nil {
+			return object.NewError("%s", err)
+		}
+		return result
+	}, arity, nil
+}
+
+// MustFunc は Func と同じですが、エラーの場合は panic します。
+func MustFunc(fn any, defaults ...any) Modifier {
+	mod, err := Func(fn, defaults...)
+	if err != nil {
+		panic(err)
+	}
+	return mod
+}
+
+func defaultValue(d any, t reflect.Type) (reflect.Value, error) {
+	if d == nil {
+		switch t.Kind() {
+		case reflect.Interface, reflect.Ptr, reflect.Slice, reflect.Map:
+			return reflect.Zero(t), nil
+		}
+		return reflect.Value{}, fmt.Errorf("cannot use nil as %s", t)
+	}
+	v := reflect.ValueOf(d)
+	switch {
+	case v.Type().AssignableTo(t):
+		return v, nil
+	case v.Type().ConvertibleTo(t) && (v.Kind() == reflect.String) == (t.Kind() == reflect.String):
+		return v.Convert(t), nil
+	default:
+		return reflect.Value{}, fmt.Errorf("cannot use %T as %s", d, t)
+	}
+}
+
+func arityMessage(required, max int, variadic bool, got int) string {
+	switch {
+	case variadic:
+		return fmt.Sprintf("want at least %d arguments, got %d", required, got)
+	case required == max:
+		return fmt.Sprintf("want %d arguments, got %d", max, got)
+	default:
+		return fmt.Sprintf("want %d to %d arguments, got %d", required, max, got)
+	}
+}
@@ -0,0 +1,88 @@
+package object
+
+import (
+	"fmt"
+	"iter"
+	"sync"
+)
+
+// 独自の型をテンプレートで組み込みの型と同じように扱うためのインターフェースです。
+// Object を実装した値は WithVariable などでそのまま渡され、評価器は以下のインターフェースを
+// 実装していればそれを優先して使います。
+//
+//	type Money struct{ Amount int64; Currency string }
+//
+//	func (m Money) Type() object.ObjectType { return MoneyType }
+//	func (m Money) Inspect() string         { return fmt.Sprintf("%d %s", m.Amount, m.Currency) }
+//	func (m Money) Truthy() bool            { return m.Amount != 0 }
+
+// Truthy は {if $obj} で真偽を判定できるオブジェクトです。
+type Truthy interface {
+	Object
+	Truthy() bool
+}
+
+// Comparable は ==, <, > などで比較できるオブジェクトです。
+// 比較できない相手の場合は false を返します。
+type Comparable interface {
+	Object
+	// Compare は other と比較し、-1, 0, 1 のいずれかを返します。
+	Compare(other Object) (int, bool)
+}
+
+// FieldAccessor は {$obj.name} でフィールドを参照できるオブジェクトです。
+type FieldAccessor interface {
+	Object
+	Field(name string) (Object, bool)
+}
+
+// Indexable は {$obj[key]} で要素を参照できるオブジェクトです。
+type Indexable interface {
+	Object
+	Index(key Object) (Object, bool)
+}
+
+// Iterable は {foreach} で走査できるオブジェクトです。
+// Len() int も実装していれば、{$item@total} はその値になります。
+type Iterable interface {
+	Object
+	// All はキーと値の組を順に返します。
+	All() iter.Seq2[Object, Object]
+}
+
+// Renderer は出力するときに Inspect とは別の表現を使うオブジェクトです。
+// 返した値は通常の値と同じくエスケープされるため、HTML を出力する場合は NewHTML を返します。
+type Renderer interface {
+	Object
+	Render() Object
+}
+
+var (
+	_ FieldAccessor = (*Time)(nil)
+	_ FieldAccessor = (*Duration)(nil)
+	_ Iterable      = (*Sequence)(nil)
+)
+
+var (
+	objectTypeMu sync.RWMutex
+	// nextObjectType は RegisterObjectType が次に発行する ObjectType です。
+	// 組み込みの型が増えても重ならないよう、離れた値から発行します。
+	nextObjectType ObjectType = 1000
+)
+
+// RegisterObjectType は独自の型のための ObjectType を発行します。
+// name はエラーメッセージなどで使われます。パッケージの初期化時に呼び出してください。
+func RegisterObjectType(name string) ObjectType {
+	objectTypeMu.Lock()
+	defer objectTypeMu.Unlock()
+
+	for t, n := range objectTypeNames {
+		if n == name {
+			panic(fmt.Sprintf("object type %q is already registered as %d", name, int(t)))
+		}
+	}
+	t := nextObjectType
+	nextObjectType++
+	objectTypeNames[t] = name
+	return t
+}
@@ -0,0 +1,346 @@
+package object
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+var (
+	objectInterface = reflect.TypeFor[Object]()
+	timeType        = reflect.TypeFor[time.Time]()
+	durationType    = reflect.TypeFor[time.Duration]()
+	bigIntType      = reflect.TypeFor[big.Int]()
+	bigRatType      = reflect.TypeFor[big.Rat]()
+)
+
+// ToGo はオブジェクトを、Goの関数やメソッドの引数として渡せる型 t の値に変換します。
+// 変換できない場合はエラーを返します。
+func ToGo(obj Object, t reflect.Type) (reflect.Value, error) {
+	if obj == nil {
+		obj = NULL
+	}
+	if opt, ok := obj.(*Optional); ok {
+		obj = opt.Unwrap()
+	}
+
+	// object.Object を受け取る引数にはそのまま渡す
+	if t.Implements(objectInterface) && reflect.TypeOf(obj).AssignableTo(t) {
+		return reflect.ValueOf(obj), nil
+	}
+	// 遅延評価のオブジェクトは、包んでいるGoの値をそのまま渡せる
+	if st, ok := obj.(*LazyStruct); ok {
+		if v := st.Value(); v.Type().AssignableTo(t) {
+			return v, nil
+		}
+		if v := reflect.Indirect(st.Value()); v.Type().AssignableTo(t) {
+			return v, nil
+		}
+	}
+
+	// iter.Seq などはそのまま渡せる
+	if seq, ok := obj.(*Sequence); ok && seq.Value().Type().AssignableTo(t) {
+		return seq.Value(), nil
+	}
+
+	switch t {
+	case timeType:
+		if tm, ok := obj.(*Time); ok {
+			return reflect.ValueOf(tm.Value), nil
+		}
+		return reflect.Value{}, conversionError(obj, t)
+	case durationType:
+		if d, ok := obj.(*Duration); ok {
+			return reflect.ValueOf(d.Value), nil
+		}
+		return reflect.Value{}, conversionError(obj, t)
+	case bigIntType, reflect.PointerTo(bigIntType):
+		i, ok := toBigInt(obj)
+		if !ok {
+			return reflect.Value{}, conversionError(obj, t)
+		}
+		if t.Kind() == reflect.Ptr {
+			return reflect.ValueOf(i), nil
+		}
+		return reflect.ValueOf(i).Elem(), nil
+	case bigRatType, reflect.PointerTo(bigRatType):
+		r, ok := ToRat(obj)
+		if !ok {
+			return reflect.Value{}, conversionError(obj, t)
+		}
+		if t.Kind() == reflect.Ptr {
+			return reflect.ValueOf(r), nil
+		}
+		return reflect.ValueOf(r).Elem(), nil
+	}
+
+	v := reflect.New(t).Elem()
+	switch t.Kind() {
+	case reflect.Interface:
+		if obj.Type() == NullType {
+			return v, nil
+		}
+		goVal := toNative(obj)
+		if !reflect.TypeOf(goVal).AssignableTo(t) {
+			return reflect.Value{}, conversionError(obj, t)
+		}
+		v.Set(reflect.ValueOf(goVal))
+	case reflect.String:
+		switch obj.Type() {
+		case StringType, HTMLType, NumberType, IntegerType, DecimalType:
+			v.SetString(obj.Inspect())
+		default:
+			return reflect.Value{}, conversionError(obj, t)
+		}
+	case reflect.Bool:
+		b, ok := obj.(*Boolean)
+		if !ok {
+			return reflect.Value{}, conversionError(obj, t)
+		}
+		v.SetBool(b.Value)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, ok := toBigInt(obj)
+		if !ok || !i.IsInt64() || v.OverflowInt(i.Int64()) {
+			return reflect.Value{}, conversionError(obj, t)
+		}
+		v.SetInt(i.Int64())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		i, ok := toBigInt(obj)
+		if !ok || !i.IsUint64() || v.OverflowUint(i.Uint64()) {
+			return reflect.Value{}, conversionError(obj, t)
+		}
+		v.SetUint(i.Uint64())
+	case reflect.Float32, reflect.Float64:
+		f, ok := toFloat(obj)
+		if !ok {
+			return reflect.Value{}, conversionError(obj, t)
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		arr, ok := obj.(ArrayLike)
+		if !ok {
+			return reflect.Value{}, conversionError(obj, t)
+		}
+		v = reflect.MakeSlice(t, arr.Len(), arr.Len())
+		for i := range arr.Len() {
+			elem, _ := arr.At(i)
+			ev, err := ToGo(elem, t.Elem())
+			if err != nil {
+				return reflect.Value{}, fmt.Errorf("index %d: %w", i, err)
+			}
+			v.Index(i).Set(ev)
+		}
+	case reflect.Map:
+		m, ok := obj.(MapLike)
+		if !ok || t.Key().Kind() != reflect.String {
+			return reflect.Value{}, conversionError(obj, t)
+		}
+		v = reflect.MakeMapWithSize(t, m.Len())
+		for _, key := range m.Keys() {
+			elem, _ := m.Get(key)
+			ev, err := ToGo(elem, t.Elem())
+			if err != nil {
+				return reflect.Value{}, fmt.Errorf("key %q: %w", key, err)
+			}
+			v.SetMapIndex(reflect.ValueOf(key).Convert(t.Key()), ev)
+		}
+	case reflect.Ptr:
+		if obj.Type() == NullType {
+			return v, nil
+		}
+		ev, err := ToGo(obj, t.Elem())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		v = reflect.New(t.Elem())
+		v.Elem().Set(ev)
+	default:
+		return reflect.Value{}, conversionError(obj, t)
+	}
+	return v, nil
+}
+
+func conversionError(obj Object, t reflect.Type) error {
+	return fmt.Errorf("cannot use %s value %q as %s", obj.Type(), obj.Inspect(), t)
+}
+
+// toBigInt は整数の値を持つ数値、または整数として解釈できる文字列を big.Int に変換します。
+func toBigInt(obj Object) (*big.Int, bool) {
+	switch v := obj.(type) {
+	case *Integer:
+		return new(big.Int).Set(v.Value), true
+	case *Decimal:
+		if !v.Value.IsInt() {
+			return nil, false
+		}
+		return new(big.Int).Set(v.Value.Num()), true
+	case *Number:
+		if math.IsInf(v.Value, 0) || v.Value != math.Trunc(v.Value) {
+			return nil, false
+		}
+		i, _ := big.NewFloat(v.Value).Int(nil)
+		return i, true
+	case *String:
+		return new(big.Int).SetString(v.Value, 10)
+	default:
+		return nil, false
+	}
+}
+
+// toFloat は数値、または数値として解釈できる文字列を float64 に変換します。
+func toFloat(obj Object) (float64, bool) {
+	switch v := obj.(type) {
+	case *Number:
+		return v.Value, true
+	case *Integer:
+		f, _ := new(big.Float).SetInt(v.Value).Float64()
+		return f, true
+	case *Decimal:
+		f, _ := v.Value.Float64()
+		return f, true
+	case *String:
+		f, err := strconv.ParseFloat(v.Value, 64)
+		return f, err == nil
+	default:
+		return 0, false
+	}
+}
+
+// JSONValue はオブジェクトを json.Marshal で出力できるGoの値に変換します。
+// マップは OrderedKeys の順にキーを並べたJSONのオブジェクトになり、
+// 大きな整数や小数は float64 に変換せずにそのまま出力されます。
+// 遅延評価の値が循環している場合はエラーを返します。
+func JSONValue(obj Object) (any, error) {
+	return (&converter{}).jsonValue(obj)
+}
+
+func (c *converter) jsonValue(obj Object) (any, error) {
+	if rv, ok := reference(obj); ok {
+		leave, err := c.enter(rv)
+		if err != nil {
+			return nil, err
+		}
+		defer leave()
+	}
+
+	switch v := obj.(type) {
+	case nil, *Null:
+		return nil, nil
+	case *String, *HTML, *Boolean, *Number, *Time, *Duration:
+		return toNative(v), nil
+	case *Integer, *Decimal:
+		return json.Number(v.Inspect()), nil
+	case *Optional:
+		return c.jsonValue(v.Unwrap())
+	case ArrayLike:
+		values := make([]any, v.Len())
+		for i := range values {
+			elem, _ := v.At(i)
+			val, err := c.jsonValue(elem)
+			if err != nil {
+				return nil, fmt.Errorf("index %d: %w", i, err)
+			}
+			values[i] = val
+		}
+		return values, nil
+	case MapLike:
+		var o jsonObject
+		for _, key := range OrderedKeys(v) {
+			elem, ok := v.Get(key)
+			if !ok {
+				continue
+			}
+			val, err := c.jsonValue(elem)
+			if err != nil {
+				return nil, fmt.Errorf("key %s: %w", key, err)
+			}
+			o.keys = append(o.keys, key)
+			o.values = append(o.values, val)
+		}
+		return o, nil
+	case json.Marshaler:
+		return v, nil
+	default:
+		return obj.Inspect(), nil
+	}
+}
+
+// jsonObject はキーの順序を保ったJSONのオブジェクトです。
+type jsonObject struct {
+	keys   []string
+	values []any
+}
+
+func (o jsonObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range o.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		v, err := json.Marshal(o.values[i])
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", key, err)
+		}
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// toNative はオブジェクトを any として扱えるGoの値に変換します。
+func toNative(obj Object) any {
+	switch v := obj.(type) {
+	case *Null:
+		return nil
+	case *String:
+		return v.Value
+	case *HTML:
+		return v.Value
+	case *Boolean:
+		return v.Value
+	case *Number:
+		return v.Value
+	case *Integer:
+		if v.Value.IsInt64() {
+			return v.Value.Int64()
+		}
+		return new(big.Int).Set(v.Value)
+	case *Decimal:
+		// float64 では精度が失われるため、正確な10進数の文字列として渡す
+		return json.Number(v.Inspect())
+	case *Time:
+		return v.Value
+	case *Duration:
+		return v.Value
+	case *LazyStruct:
+		return v.Value().Interface()
+	case ArrayLike:
+		values := make([]any, v.Len())
+		for i := range values {
+			elem, _ := v.At(i)
+			values[i] = toNative(elem)
+		}
+		return values
+	case MapLike:
+		values := make(map[string]any, v.Len())
+		for _, key := range v.Keys() {
+			elem, _ := v.Get(key)
+			values[key] = toNative(elem)
+		}
+		return values
+	default:
+		return obj
+	}
+}
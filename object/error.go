@@ -0,0 +1,26 @@
+package object
+
+import "fmt"
+
+// Error はテンプレートの評価中に発生したエラーを表します。
+// Error が返された時点で評価は中断され、Execute の結果として返されます。
+type Error struct {
+	Message string
+}
+
+func NewError(format string, a ...any) *Error {
+	return &Error{Message: fmt.Sprintf(format, a...)}
+}
+
+func (e *Error) Type() ObjectType {
+	return ErrorType
+}
+
+func (e *Error) Inspect() string {
+	return "ERROR: " + e.Message
+}
+
+// Error は error インターフェースを実装します。
+func (e *Error) Error() string {
+	return e.Message
+}
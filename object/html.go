@@ -0,0 +1,19 @@
+package object
+
+// HTML はエスケープ済みで、そのまま出力してよい安全な文字列を表します。
+// 自動エスケープが有効な場合でも、HTML は再度エスケープされません。
+type HTML struct {
+	Value string
+}
+
+func NewHTML[S ~string](s S) *HTML {
+	return &HTML{Value: string(s)}
+}
+
+func (h *HTML) Type() ObjectType {
+	return HTMLType
+}
+
+func (h *HTML) Inspect() string {
+	return h.Value
+}
@@ -0,0 +1,330 @@
+package object
+
+import (
+	"fmt"
+	"math/big"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MapLike は {$m.key} や {foreach} で Map と同じように扱えるオブジェクトです。
+type MapLike interface {
+	Object
+	// Get はキーに対応する値を返します。
+	Get(key string) (Object, bool)
+	// Keys はすべてのキーを返します。順序は実装によって異なります。
+	Keys() []string
+	Len() int
+}
+
+// ArrayLike は {$a[0]} や {foreach} で Array と同じように扱えるオブジェクトです。
+type ArrayLike interface {
+	Object
+	// At は i 番目の要素を返します。範囲外の場合は false を返します。
+	At(i int) (Object, bool)
+	Len() int
+}
+
+var (
+	_ MapLike   = (*Map)(nil)
+	_ MapLike   = (*LazyStruct)(nil)
+	_ MapLike   = (*LazyMap)(nil)
+	_ ArrayLike = (*Array)(nil)
+	_ ArrayLike = (*LazyArray)(nil)
+)
+
+func (m *Map) Get(key string) (Object, bool) {
+	val, ok := m.Value[key]
+	return val, ok
+}
+
+func (m *Map) Keys() []string {
+	keys := make([]string, 0, len(m.Value))
+	for key := range m.Value {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func (m *Map) Len() int {
+	return len(m.Value)
+}
+
+func (a *Array) At(i int) (Object, bool) {
+	if i < 0 || i >= len(a.Value) {
+		return nil, false
+	}
+	return a.Value[i], true
+}
+
+func (a *Array) Len() int {
+	return len(a.Value)
+}
+
+// Wrap はGoの値を、アクセスされたときに初めて変換する遅延評価のオブジェクトで包みます。
+//
+// 構造体、文字列をキーとするマップ、スライス、配列はそれぞれ LazyStruct, LazyMap, LazyArray になり、
+// フィールドや要素は参照されたときに Wrap されます。それ以外の値は NewObjectFromAny で変換されます。
+// 大きなビューモデルのうちテンプレートが参照しない部分は変換されないため、割り当てを抑えられます。
+// 循環する値も包めます。Inspect は繰り返し現れた値を *RECURSION* と出力し、JSONValue はエラーを返します。
+func Wrap(i any) (Object, error) {
+	return wrapValue(reflect.ValueOf(i))
+}
+
+func wrapValue(rv reflect.Value) (Object, error) {
+	if !rv.IsValid() {
+		return NULL, nil
+	}
+	if rv.CanInterface() {
+		switch rv.Interface().(type) {
+		case Object:
+			return NewObjectFromAny(rv.Interface())
+		case time.Time, *time.Time, time.Duration, *string, big.Int, *big.Int, big.Rat, *big.Rat:
+			return NewObjectFromAny(rv.Interface())
+		}
+		if obj, ok, err := textValue(rv.Interface()); ok {
+			return obj, err
+		}
+	}
+
+	switch rv.Kind() {
+	case reflect.Ptr:
+		if rv.IsNil() {
+			return NULL, nil
+		}
+		if rv.Elem().Kind() == reflect.Struct {
+			// メソッドを失わないよう、ポインタのまま保持する
+			return &LazyStruct{value: rv, info: structInfoOf(rv.Type().Elem())}, nil
+		}
+		return wrapValue(rv.Elem())
+	case reflect.Interface:
+		return wrapValue(rv.Elem())
+	case reflect.Struct:
+		info := structInfoOf(rv.Type())
+		if info.dynamic {
+			rv = addressable(rv)
+		}
+		return &LazyStruct{value: rv, info: info}, nil
+	case reflect.Slice, reflect.Array:
+		return &LazyArray{value: rv}, nil
+	case reflect.Map:
+		if isIntKind(rv.Type().Key().Kind()) {
+			// 整数のキーは並べ替えが必要なため、参照された時点でまとめて変換する
+			return NewObjectFromAny(rv.Interface())
+		}
+		if rv.Type().Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("unsupported map key type: %s", rv.Type().Key().Kind())
+		}
+		return &LazyMap{value: rv}, nil
+	}
+
+	if !rv.CanInterface() {
+		return nil, fmt.Errorf("unsupported value: %s", rv.Type())
+	}
+	return NewObjectFromAny(rv.Interface())
+}
+
+// mustWrap はアクセス時の変換に失敗した値を、理由を持つ Error として返します。
+func mustWrap(rv reflect.Value) Object {
+	obj, err := wrapValue(rv)
+	if err != nil {
+		return NewError("%s", err)
+	}
+	return obj
+}
+
+// LazyStruct はGoの構造体を、フィールドが参照されたときに変換する Map です。
+type LazyStruct struct {
+	value reflect.Value // 構造体、または構造体へのポインタ
+	info  *structInfo
+
+	keysOnce sync.Once
+	keys     []string
+}
+
+func (s *LazyStruct) Type() ObjectType {
+	return MapType
+}
+
+func (s *LazyStruct) Inspect() string {
+	return (&converter{}).inspect(s)
+}
+
+func (s *LazyStruct) Get(key string) (Object, bool) {
+	field, ok := s.info.fields[key]
+	if !ok {
+		return nil, false
+	}
+	val, ok := fieldValue(reflect.Indirect(s.value), field)
+	if !ok {
+		return nil, false
+	}
+	return mustWrap(val), true
+}
+
+// Keys はフィールドを宣言順に返します。
+// omitempty でゼロ値のフィールドや、nil の埋め込みポインタのフィールドは含みません。
+func (s *LazyStruct) Keys() []string {
+	if !s.info.dynamic {
+		return s.info.names
+	}
+	s.keysOnce.Do(func() {
+		rv := reflect.Indirect(s.value)
+		for _, name := range s.info.names {
+			if _, ok := fieldValue(rv, s.info.fields[name]); ok {
+				s.keys = append(s.keys, name)
+			}
+		}
+	})
+	return s.keys
+}
+
+func (s *LazyStruct) Len() int {
+	return len(s.Keys())
+}
+
+// Value は包んでいるGoの値を返します。
+func (s *LazyStruct) Value() reflect.Value {
+	return s.value
+}
+
+// LazyMap は文字列をキーとするGoのマップを、値が参照されたときに変換する Map です。
+type LazyMap struct {
+	value reflect.Value
+}
+
+func (m *LazyMap) Type() ObjectType {
+	return MapType
+}
+
+func (m *LazyMap) Inspect() string {
+	return (&converter{}).inspect(m)
+}
+
+func (m *LazyMap) Get(key string) (Object, bool) {
+	val := m.value.MapIndex(reflect.ValueOf(key).Convert(m.value.Type().Key()))
+	if !val.IsValid() {
+		return nil, false
+	}
+	return mustWrap(val), true
+}
+
+func (m *LazyMap) Keys() []string {
+	keys := make([]string, 0, m.value.Len())
+	iter := m.value.MapRange()
+	for iter.Next() {
+		keys = append(keys, iter.Key().String())
+	}
+	return keys
+}
+
+func (m *LazyMap) Len() int {
+	return m.value.Len()
+}
+
+// LazyArray はGoのスライスや配列を、要素が参照されたときに変換する Array です。
+type LazyArray struct {
+	value reflect.Value
+}
+
+func (a *LazyArray) Type() ObjectType {
+	return ArrayType
+}
+
+func (a *LazyArray) Inspect() string {
+	return (&converter{}).inspect(a)
+}
+
+func (a *LazyArray) At(i int) (Object, bool) {
+	if i < 0 || i >= a.value.Len() {
+		return nil, false
+	}
+	return mustWrap(a.value.Index(i)), true
+}
+
+func (a *LazyArray) Len() int {
+	return a.value.Len()
+}
+
+func inspectMapLike(m MapLike, keys []string) string {
+	return (&converter{}).inspectMapLike(m, keys)
+}
+
+// reference は遅延評価のオブジェクトが参照しているGoのポインタ、マップ、スライスを返します。
+// 循環を検出するために使います。
+func reference(obj Object) (reflect.Value, bool) {
+	var rv reflect.Value
+	switch v := obj.(type) {
+	case *LazyStruct:
+		rv = v.value
+	case *LazyMap:
+		rv = v.value
+	case *LazyArray:
+		rv = v.value
+	default:
+		return reflect.Value{}, false
+	}
+	switch rv.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice:
+		return rv, !rv.IsNil()
+	}
+	return reflect.Value{}, false
+}
+
+// inspect は obj を文字列にします。
+// 遅延評価の値が循環している場合は、たどっている途中の値を *RECURSION* と出力します。
+func (c *converter) inspect(obj Object) string {
+	if rv, ok := reference(obj); ok {
+		leave, err := c.enter(rv)
+		if err != nil {
+			return "*RECURSION*"
+		}
+		defer leave()
+	}
+
+	switch v := obj.(type) {
+	case *LazyStruct:
+		return c.inspectMapLike(v, v.Keys())
+	case *LazyMap:
+		keys := v.Keys()
+		sort.Strings(keys)
+		return c.inspectMapLike(v, keys)
+	case *LazyArray:
+		elements := make([]string, v.Len())
+		for i := range elements {
+			elem, _ := v.At(i)
+			elements[i] = c.inspect(elem)
+		}
+		return "[" + strings.Join(elements, ", ") + "]"
+	default:
+		return obj.Inspect()
+	}
+}
+
+func (c *converter) inspectMapLike(m MapLike, keys []string) string {
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		val, ok := m.Get(key)
+		if !ok {
+			continue
+		}
+		pairs = append(pairs, key+":"+c.inspect(val))
+	}
+	return "{" + strings.Join(pairs, ", ") + "}"
+}
+
+// Method は名前に対応するメソッドを返します。
+// 値として保持している構造体でも、ポインタレシーバのメソッドを呼び出せるようにコピーのポインタを使います。
+func (s *LazyStruct) Method(name string) (reflect.Value, bool) {
+	v := s.value
+	if v.Kind() != reflect.Ptr {
+		ptr := reflect.New(v.Type())
+		ptr.Elem().Set(v)
+		v = ptr
+	}
+	method := v.MethodByName(name)
+	return method, method.IsValid()
+}
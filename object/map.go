@@ -1,7 +1,7 @@
 package object
 
 import (
-	"strings"
+	"sort"
 )
 
 // Map はマップ（ハッシュ）オブジェクトを表します。
@@ -14,17 +14,12 @@ func (m *Map) Type() ObjectType {
 	return MapType
 }
 
+// Inspect はキーの辞書順に出力します。
 func (m *Map) Inspect() string {
-	var out strings.Builder
-
-	var pairs []string
-	for key, value := range m.Value {
-		pairs = append(pairs, key+":"+value.Inspect())
+	keys := make([]string, 0, len(m.Value))
+	for key := range m.Value {
+		keys = append(keys, key)
 	}
-
-	out.WriteString("{")
-	out.WriteString(strings.Join(pairs, ", "))
-	out.WriteString("}")
-
-	return out.String()
+	sort.Strings(keys)
+	return inspectMapLike(m, keys)
 }
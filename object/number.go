@@ -1,7 +1,9 @@
 package object
 
-import "fmt"
+import "strconv"
 
+// Number は浮動小数点数です。
+// 整数は Integer、正確な10進数は Decimal で表します。
 type Number struct {
 	Value float64
 }
@@ -10,6 +12,7 @@ func (n *Number) Type() ObjectType {
 	return NumberType
 }
 
+// Inspect は指数表記を使わずに、値を表せる最短の10進数で返します (e.g., 1500000, 0.1)。
 func (n *Number) Inspect() string {
-	return fmt.Sprintf("%g", n.Value)
+	return strconv.FormatFloat(n.Value, 'f', -1, 64)
 }
@@ -0,0 +1,255 @@
+package object
+
+import (
+	"math"
+	"math/big"
+	"strings"
+
+	"golang.org/x/exp/constraints"
+)
+
+// Integer は任意精度の整数です。
+// int64 の ID や math/big.Int を、float64 を経由せずに正確に扱います。
+type Integer struct {
+	Value *big.Int
+}
+
+func NewInteger[I constraints.Integer](i I) *Integer {
+	if i < 0 {
+		return &Integer{Value: big.NewInt(int64(i))}
+	}
+	return &Integer{Value: new(big.Int).SetUint64(uint64(i))}
+}
+
+// NewBigInt は i のコピーを持つ Integer を作成します。
+func NewBigInt(i *big.Int) *Integer {
+	return &Integer{Value: new(big.Int).Set(i)}
+}
+
+func (i *Integer) Type() ObjectType {
+	return IntegerType
+}
+
+func (i *Integer) Inspect() string {
+	return i.Value.String()
+}
+
+// Decimal は math/big.Rat による正確な10進数です。
+// 0.1 + 0.2 のような計算や金額を誤差なく扱います。
+type Decimal struct {
+	Value *big.Rat
+}
+
+// NewDecimal は r のコピーを持つ Decimal を作成します。
+func NewDecimal(r *big.Rat) *Decimal {
+	return &Decimal{Value: new(big.Rat).Set(r)}
+}
+
+// ParseDecimal は "12.50" や "-0.1" のような10進数の文字列を Decimal に変換します。
+func ParseDecimal(s string) (*Decimal, bool) {
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return nil, false
+	}
+	return &Decimal{Value: r}, true
+}
+
+func (d *Decimal) Type() ObjectType {
+	return DecimalType
+}
+
+// Inspect は値を正確に表せる最小の桁数で返します。
+// 循環小数になる場合は小数点以下 maxDecimalDigits 桁で丸めます。
+func (d *Decimal) Inspect() string {
+	if d.Value.IsInt() {
+		return d.Value.Num().String()
+	}
+	digits, exact := decimalDigits(d.Value.Denom())
+	if !exact {
+		s := d.Value.FloatString(maxDecimalDigits)
+		return strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	}
+	return d.Value.FloatString(digits)
+}
+
+const maxDecimalDigits = 16
+
+var (
+	bigTwo  = big.NewInt(2)
+	bigFive = big.NewInt(5)
+)
+
+// decimalDigits は分母 denom の分数を有限小数で表すのに必要な桁数を返します。
+// 分母が2と5以外の素因数を持つ場合は false を返します。
+func decimalDigits(denom *big.Int) (int, bool) {
+	n := new(big.Int).Set(denom)
+	var twos, fives int
+	rem := new(big.Int)
+	for {
+		if q, r := new(big.Int).QuoRem(n, bigTwo, rem); r.Sign() == 0 {
+			n = q
+			twos++
+			continue
+		}
+		break
+	}
+	for {
+		if q, r := new(big.Int).QuoRem(n, bigFive, rem); r.Sign() == 0 {
+			n = q
+			fives++
+			continue
+		}
+		break
+	}
+	return max(twos, fives), n.IsInt64() && n.Int64() == 1
+}
+
+// IsNumeric は obj が Integer, Decimal, Number のいずれかであれば true を返します。
+func IsNumeric(obj Object) bool {
+	switch obj.(type) {
+	case *Integer, *Decimal, *Number:
+		return true
+	default:
+		return false
+	}
+}
+
+// AddNumbers は数値どうしの和を返します。
+//
+//	Integer + Integer -> Integer
+//	Integer + Decimal -> Decimal
+//	Decimal + Decimal -> Decimal
+//	Number  + (any)   -> Number
+func AddNumbers(left, right Object) (Object, bool) {
+	return arith(left, right, (*big.Int).Add, (*big.Rat).Add, func(a, b float64) float64 { return a + b })
+}
+
+// SubNumbers は数値どうしの差を返します。型の規則は AddNumbers と同じです。
+func SubNumbers(left, right Object) (Object, bool) {
+	return arith(left, right, (*big.Int).Sub, (*big.Rat).Sub, func(a, b float64) float64 { return a - b })
+}
+
+func arith(
+	left, right Object,
+	intOp func(z, x, y *big.Int) *big.Int,
+	ratOp func(z, x, y *big.Rat) *big.Rat,
+	floatOp func(x, y float64) float64,
+) (Object, bool) {
+	if !IsNumeric(left) || !IsNumeric(right) {
+		return nil, false
+	}
+	l, lok := left.(*Integer)
+	r, rok := right.(*Integer)
+	switch {
+	case lok && rok:
+		return &Integer{Value: intOp(new(big.Int), l.Value, r.Value)}, true
+	case isExact(left) && isExact(right):
+		return &Decimal{Value: ratOp(new(big.Rat), toRat(left), toRat(right))}, true
+	default:
+		lf, _ := toFloat(left)
+		rf, _ := toFloat(right)
+		return &Number{Value: floatOp(lf, rf)}, true
+	}
+}
+
+// CompareNumbers は数値どうしを比較し、-1, 0, 1 のいずれかを返します。
+// Integer と Decimal は正確に比較します。
+func CompareNumbers(left, right Object) (int, bool) {
+	if !IsNumeric(left) || !IsNumeric(right) {
+		return 0, false
+	}
+	if isExact(left) && isExact(right) {
+		return toRat(left).Cmp(toRat(right)), true
+	}
+	lf, _ := toFloat(left)
+	rf, _ := toFloat(right)
+	switch {
+	case lf < rf:
+		return -1, true
+	case lf > rf:
+		return 1, true
+	default:
+		return 0, true
+	}
+}
+
+// Sign は数値の符号を -1, 0, 1 で返します。
+func Sign(obj Object) (int, bool) {
+	switch v := obj.(type) {
+	case *Integer:
+		return v.Value.Sign(), true
+	case *Decimal:
+		return v.Value.Sign(), true
+	case *Number:
+		switch {
+		case v.Value < 0:
+			return -1, true
+		case v.Value > 0:
+			return 1, true
+		default:
+			return 0, true
+		}
+	default:
+		return 0, false
+	}
+}
+
+// ToInt は整数として表せる数値を int に変換します。
+func ToInt(obj Object) (int, bool) {
+	switch v := obj.(type) {
+	case *Integer:
+		if !v.Value.IsInt64() || int64(int(v.Value.Int64())) != v.Value.Int64() {
+			return 0, false
+		}
+		return int(v.Value.Int64()), true
+	case *Decimal:
+		if !v.Value.IsInt() {
+			return 0, false
+		}
+		return ToInt(&Integer{Value: v.Value.Num()})
+	case *Number:
+		if v.Value != math.Trunc(v.Value) || math.Abs(v.Value) > 1<<53 {
+			return 0, false
+		}
+		return int(v.Value), true
+	default:
+		return 0, false
+	}
+}
+
+// ToRat は数値を big.Rat に変換します。Number は2進数の値をそのまま変換します。
+func ToRat(obj Object) (*big.Rat, bool) {
+	switch v := obj.(type) {
+	case *Integer:
+		return toRat(v), true
+	case *Decimal:
+		return new(big.Rat).Set(v.Value), true
+	case *Number:
+		if math.IsInf(v.Value, 0) || math.IsNaN(v.Value) {
+			return nil, false
+		}
+		return new(big.Rat).SetFloat64(v.Value), true
+	default:
+		return nil, false
+	}
+}
+
+func isExact(obj Object) bool {
+	switch obj.(type) {
+	case *Integer, *Decimal:
+		return true
+	default:
+		return false
+	}
+}
+
+func toRat(obj Object) *big.Rat {
+	switch v := obj.(type) {
+	case *Integer:
+		return new(big.Rat).SetInt(v.Value)
+	case *Decimal:
+		return v.Value
+	default:
+		return nil
+	}
+}
@@ -1,8 +1,11 @@
 package object
 
 import (
+	"cmp"
 	"fmt"
+	"math/big"
 	"reflect"
+	"slices"
 	"time"
 )
 
@@ -24,8 +27,40 @@ const (
 	MapType
 	TimeType
 	OptionalType
+	DurationType
+	HTMLType
+	ErrorType
+	IntegerType
+	DecimalType
+	SequenceType
 )
 
+var objectTypeNames = map[ObjectType]string{
+	StringType:   "string",
+	BoolType:     "bool",
+	NullType:     "null",
+	NumberType:   "number",
+	ArrayType:    "array",
+	MapType:      "map",
+	TimeType:     "time",
+	OptionalType: "optional",
+	DurationType: "duration",
+	HTMLType:     "html",
+	ErrorType:    "error",
+	IntegerType:  "integer",
+	DecimalType:  "decimal",
+	SequenceType: "sequence",
+}
+
+func (t ObjectType) String() string {
+	objectTypeMu.RLock()
+	defer objectTypeMu.RUnlock()
+	if name, ok := objectTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("ObjectType(%d)", int(t))
+}
+
 type Object interface {
 	Type() ObjectType
 	// デバッグや出力のためにオブジェクトの状態を文字列で返す
@@ -33,24 +68,97 @@ type Object interface {
 }
 
 // Goのネイティブな型をgosmartyのObjectに変換
+//
+// 構造体のフィールドは gosmarty タグ、json タグの順に名前を決め、埋め込まれた構造体のフィールドは昇格します。
+// encoding.TextMarshaler か fmt.Stringer を実装した値は文字列になります。
+// ポインタ、マップ、スライスが循環している場合はエラーを返します。循環する値は Wrap で遅延評価してください。
 func NewObjectFromAny(i any) (Object, error) {
+	return (&converter{}).fromAny(i)
+}
+
+// converter は変換中にたどっているポインタ、マップ、スライスを記録し、循環を検出します。
+type converter struct {
+	visiting map[visit]bool
+}
+
+type visit struct {
+	ptr uintptr
+	typ reflect.Type
+	len int
+}
+
+// enter は rv をたどり始めます。既にたどっている途中であればエラーを返します。
+func (c *converter) enter(rv reflect.Value) (func(), error) {
+	v := visit{ptr: rv.Pointer(), typ: rv.Type()}
+	if rv.Kind() == reflect.Slice {
+		if rv.Len() == 0 {
+			return func() {}, nil
+		}
+		v.len = rv.Len()
+	}
+	if c.visiting[v] {
+		return nil, fmt.Errorf("encountered a cycle via %s", rv.Type())
+	}
+	if c.visiting == nil {
+		c.visiting = map[visit]bool{}
+	}
+	c.visiting[v] = true
+	return func() { delete(c.visiting, v) }, nil
+}
+
+func (c *converter) fromAny(i any) (Object, error) {
+	switch rv := reflect.ValueOf(i); rv.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice:
+		if !rv.IsNil() {
+			leave, err := c.enter(rv)
+			if err != nil {
+				return nil, err
+			}
+			defer leave()
+		}
+	}
+
 	switch i := i.(type) {
 	case nil:
 		return NULL, nil
+	case Object:
+		// 独自の型はそのまま渡す
+		if rv := reflect.ValueOf(i); rv.Kind() == reflect.Ptr && rv.IsNil() {
+			return NULL, nil
+		}
+		return i, nil
 	case string:
 		return NewString(i), nil
 	case *string:
+		// nil は値のない Optional になる
+		if i == nil {
+			return NewOptional(nil), nil
+		}
 		return NewOptional(NewString(*i)), nil
 	case int:
-		return &Number{Value: float64(i)}, nil
+		return NewInteger(i), nil
 	case int64:
-		return &Number{Value: float64(i)}, nil
+		return NewInteger(i), nil
 	case uint:
-		return &Number{Value: float64(i)}, nil
+		return NewInteger(i), nil
 	case uint64:
-		return &Number{Value: float64(i)}, nil
+		return NewInteger(i), nil
 	case float64:
 		return &Number{Value: i}, nil
+	case big.Int:
+		return NewBigInt(&i), nil
+	case *big.Int:
+		if i == nil {
+			return NULL, nil
+		}
+		return NewBigInt(i), nil
+	case big.Rat:
+		return NewDecimal(&i), nil
+	case *big.Rat:
+		if i == nil {
+			return NULL, nil
+		}
+		return NewDecimal(i), nil
 	case bool:
 		if i {
 			return TRUE, nil
@@ -65,7 +173,7 @@ func NewObjectFromAny(i any) (Object, error) {
 	case []map[string]any:
 		values := make([]Object, len(i))
 		for idx, elem := range i {
-			obj, err := NewObjectFromAny(elem)
+			obj, err := c.fromAny(elem)
 			if err != nil {
 				return nil, err
 			}
@@ -75,7 +183,7 @@ func NewObjectFromAny(i any) (Object, error) {
 	case []any:
 		values := make([]Object, len(i))
 		for idx, elem := range i {
-			obj, err := NewObjectFromAny(elem)
+			obj, err := c.fromAny(elem)
 			if err != nil {
 				return nil, err
 			}
@@ -85,7 +193,7 @@ func NewObjectFromAny(i any) (Object, error) {
 	case map[string]any:
 		pairs := make(map[string]Object)
 		for key, val := range i {
-			obj, err := NewObjectFromAny(val)
+			obj, err := c.fromAny(val)
 			if err != nil {
 				return nil, err
 			}
@@ -95,11 +203,17 @@ func NewObjectFromAny(i any) (Object, error) {
 	case time.Time:
 		return NewTime(i), nil
 	case *time.Time:
+		if i == nil {
+			return NULL, nil
+		}
 		return NewTime(*i), nil
-	// todo: support go stdlib package types
-	// case *big.Rat:
-	// case *big.Int:
+	case time.Duration:
+		return NewDuration(i), nil
 	default:
+		if obj, ok, err := textValue(i); ok {
+			return obj, err
+		}
+
 		rv := reflect.ValueOf(i)
 		// underlying types or structs
 		switch rv.Kind() {
@@ -107,24 +221,27 @@ func NewObjectFromAny(i any) (Object, error) {
 			if rv.IsNil() {
 				return NULL, nil
 			}
-			return NewObjectFromAny(rv.Elem().Interface())
+			return c.fromAny(rv.Elem().Interface())
 		case reflect.String:
 			return NewString(rv.String()), nil
-		case reflect.Int:
-			return &Number{Value: float64(rv.Int())}, nil
-		case reflect.Int64:
-			return &Number{Value: float64(rv.Int())}, nil
-		case reflect.Uint:
-			return &Number{Value: float64(rv.Uint())}, nil
-		case reflect.Uint64:
-			return &Number{Value: float64(rv.Uint())}, nil
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return NewInteger(rv.Int()), nil
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			return NewInteger(rv.Uint()), nil
+		case reflect.Float32, reflect.Float64:
+			return &Number{Value: rv.Float()}, nil
 		case reflect.Bool:
 			return &Boolean{Value: rv.Bool()}, nil
+		case reflect.Func, reflect.Chan:
+			// iter.Seq, iter.Seq2 とチャネルは {foreach} で走査されるまで読み出さない
+			if seq, ok := newSequence(rv); ok {
+				return seq, nil
+			}
 		case reflect.Slice, reflect.Array:
 			length := rv.Len()
 			values := make([]Object, length)
 			for idx := 0; idx < length; idx++ {
-				obj, err := NewObjectFromAny(rv.Index(idx).Interface())
+				obj, err := c.fromAny(rv.Index(idx).Interface())
 				if err != nil {
 					return nil, err
 				}
@@ -132,13 +249,16 @@ func NewObjectFromAny(i any) (Object, error) {
 			}
 			return &Array{Value: values}, nil
 		case reflect.Map:
+			if isIntKind(rv.Type().Key().Kind()) {
+				return c.fromIntKeyMap(rv)
+			}
 			if rv.Type().Key().Kind() != reflect.String {
 				return nil, fmt.Errorf("unsupported map key type: %s", rv.Type().Key().Kind())
 			}
 			pairs := make(map[string]Object)
 			iter := rv.MapRange()
 			for iter.Next() {
-				valObj, err := NewObjectFromAny(iter.Value().Interface())
+				valObj, err := c.fromAny(iter.Value().Interface())
 				if err != nil {
 					return nil, err
 				}
@@ -146,35 +266,56 @@ func NewObjectFromAny(i any) (Object, error) {
 			}
 			return &Map{Value: pairs}, nil
 		case reflect.Struct:
-			pairs := make(map[string]Object)
-			rt := reflect.TypeOf(i)
-			for i := range rv.NumField() {
-				val := rv.Field(i)
-				typ := rt.Field(i)
-				key := typ.Name
-				if val.Kind() == reflect.Func {
-					// skip function field
-					continue
-				}
-				if fieldTag := typ.Tag.Get("gosmarty"); fieldTag != "" {
-					key = fieldTag
-				}
-				if key == "-" {
-					// skip fields with "-" tag
+			// フィールドの宣言順を保つ
+			info := structInfoOf(rv.Type())
+			if info.dynamic {
+				rv = addressable(rv)
+			}
+			pairs := NewOrderedMap()
+			for _, name := range info.names {
+				field := info.fields[name]
+				val, ok := fieldValue(rv, field)
+				if !ok {
 					continue
 				}
-
-				valObj, err := NewObjectFromAny(val.Interface())
+				valObj, err := c.fromAny(val.Interface())
 				if err != nil {
-					return nil, fmt.Errorf("failed to convert field %s: %w", typ.Name, err)
+					return nil, fmt.Errorf("failed to convert field %s: %w", name, err)
 				}
-
-				pairs[key] = valObj
+				pairs.Set(name, valObj)
 			}
-			//todo
-			return &Map{Value: pairs}, nil
+			return pairs, nil
 		}
 
 		return nil, fmt.Errorf("unsupported type: %T", i)
 	}
 }
+
+// fromIntKeyMap は整数をキーとするマップを、PHP の配列のようにキーの昇順に並べた OrderedMap に変換します。
+func (c *converter) fromIntKeyMap(rv reflect.Value) (Object, error) {
+	keys := rv.MapKeys()
+	if rv.Type().Key().Kind() >= reflect.Uint && rv.Type().Key().Kind() <= reflect.Uintptr {
+		slices.SortFunc(keys, func(a, b reflect.Value) int { return cmp.Compare(a.Uint(), b.Uint()) })
+	} else {
+		slices.SortFunc(keys, func(a, b reflect.Value) int { return cmp.Compare(a.Int(), b.Int()) })
+	}
+
+	pairs := NewOrderedMap()
+	for _, key := range keys {
+		valObj, err := c.fromAny(rv.MapIndex(key).Interface())
+		if err != nil {
+			return nil, err
+		}
+		pairs.Set(fmt.Sprint(key.Interface()), valObj)
+	}
+	return pairs, nil
+}
+
+func isIntKind(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return true
+	}
+	return false
+}
@@ -1,7 +1,12 @@
 package object
 
 import (
+	"encoding/json"
+	"errors"
+	"math"
+	"math/big"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 )
@@ -44,17 +49,53 @@ func TestNewObjectFromAny(t *testing.T) {
 			anyVal: nil,
 			want:   &Null{},
 		},
+		{
+			anyVal: (*string)(nil),
+			want:   NewOptional(nil),
+		},
+		{
+			anyVal: (*time.Time)(nil),
+			want:   NULL,
+		},
 		{
 			anyVal: int(100),
-			want:   &Number{Value: float64(100)},
+			want:   NewInteger(100),
 		},
 		{
 			anyVal: IntUnderlying(100),
-			want:   &Number{Value: float64(100)},
+			want:   NewInteger(100),
 		},
 		{
 			anyVal: Int64Underlying(100),
-			want:   &Number{Value: float64(100)},
+			want:   NewInteger(100),
+		},
+		{
+			anyVal: int64(math.MaxInt64),
+			want:   NewInteger(int64(math.MaxInt64)),
+		},
+		{
+			anyVal: uint8(7),
+			want:   NewInteger(7),
+		},
+		{
+			anyVal: 1.5,
+			want:   &Number{Value: 1.5},
+		},
+		{
+			anyVal: new(big.Int).Lsh(big.NewInt(1), 70),
+			want:   &Integer{Value: new(big.Int).Lsh(big.NewInt(1), 70)},
+		},
+		{
+			anyVal: big.NewRat(1999, 100),
+			want:   &Decimal{Value: big.NewRat(1999, 100)},
+		},
+		{
+			anyVal: time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC),
+			want:   &Time{Value: time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)},
+		},
+		{
+			anyVal: 90 * time.Minute,
+			want:   &Duration{Value: 90 * time.Minute},
 		},
 		{
 			anyVal: []string{"1", "2", "3"},
@@ -74,12 +115,10 @@ func TestNewObjectFromAny(t *testing.T) {
 				Id:   123,
 				Name: "Alice",
 			},
-			want: &Map{
-				Value: map[string]Object{
-					"Id":   &Number{Value: 123},
-					"Name": &String{Value: "Alice"},
-				},
-			},
+			want: orderedMap(
+				"Id", NewInteger(123),
+				"Name", &String{Value: "Alice"},
+			),
 		},
 		{
 			anyVal: struct {
@@ -97,17 +136,13 @@ func TestNewObjectFromAny(t *testing.T) {
 					CreatedAt: time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC),
 				},
 			},
-			want: &Map{
-				Value: map[string]Object{
-					"Id":   &Number{Value: 123},
-					"Name": &String{Value: "Alice"},
-					"Metadata": &Map{
-						Value: map[string]Object{
-							"CreatedAt": &Time{Value: time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)},
-						},
-					},
-				},
-			},
+			want: orderedMap(
+				"Id", NewInteger(123),
+				"Name", &String{Value: "Alice"},
+				"Metadata", orderedMap(
+					"CreatedAt", &Time{Value: time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)},
+				),
+			),
 		},
 	}
 
@@ -127,3 +162,400 @@ func TestNewObjectFromAny(t *testing.T) {
 		})
 	}
 }
+
+func orderedMap(pairs ...any) *OrderedMap {
+	m := NewOrderedMap()
+	for i := 0; i < len(pairs); i += 2 {
+		m.Set(pairs[i].(string), pairs[i+1].(Object))
+	}
+	return m
+}
+
+func TestOrderedMap(t *testing.T) {
+	t.Parallel()
+
+	m := NewOrderedMap()
+	m.Set("b", NewString("B"))
+	m.Set("a", NewString("A"))
+	m.Set("5", NewString("five"))
+	m.Append(NewString("six"))
+	m.Set("b", NewString("B2"))
+
+	if want := []string{"b", "a", "5", "6"}; !reflect.DeepEqual(m.Keys(), want) {
+		t.Errorf("keys: want=%v, got=%v", want, m.Keys())
+	}
+	if got := m.Inspect(); got != "{b:B2, a:A, 5:five, 6:six}" {
+		t.Errorf("Inspect: got=%s", got)
+	}
+	if got := MapKey("5"); !reflect.DeepEqual(got, NewInteger(5)) {
+		t.Errorf("MapKey(\"5\"): got=%#v", got)
+	}
+	for _, key := range []string{"05", "+5", "5.0", "a"} {
+		if got := MapKey(key); !reflect.DeepEqual(got, NewString(key)) {
+			t.Errorf("MapKey(%q): got=%#v", key, got)
+		}
+	}
+
+	obj, err := FromJSON([]byte(`{"z": 1, "a": {"y": 2.50, "x": [true, null, "s"]}, "big": 12345678901234567890}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := obj.Inspect(); got != "{z:1, a:{y:2.5, x:[true, null, s]}, big:12345678901234567890}" {
+		t.Errorf("FromJSON: got=%s", got)
+	}
+	// キーの順序と数値の精度を保ってJSONに戻せる
+	b, err := json.Marshal(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"z":1,"a":{"y":2.5,"x":[true,null,"s"]},"big":12345678901234567890}`; string(b) != want {
+		t.Errorf("MarshalJSON: want=%s, got=%s", want, b)
+	}
+	if _, err := FromJSON([]byte(`{"a": 1} {}`)); err == nil {
+		t.Error("FromJSON should reject trailing data")
+	}
+}
+
+func TestWrap(t *testing.T) {
+	t.Parallel()
+
+	type Variant struct {
+		SKU   string `gosmarty:"sku"`
+		Price int
+	}
+	type Product struct {
+		ID       int
+		Name     string
+		Secret   string `gosmarty:"-"`
+		Variants []Variant
+		Attrs    map[string]string
+		internal string
+	}
+	product := &Product{
+		ID:       1,
+		Name:     "T-shirt",
+		Secret:   "hidden",
+		Variants: []Variant{{SKU: "TS-S", Price: 1000}, {SKU: "TS-M", Price: 1200}},
+		Attrs:    map[string]string{"color": "red"},
+		internal: "x",
+	}
+
+	obj, err := Wrap(product)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, ok := obj.(*LazyStruct)
+	if !ok {
+		t.Fatalf("want *LazyStruct, got %T", obj)
+	}
+	if st.Type() != MapType {
+		t.Errorf("want MapType, got %v", st.Type())
+	}
+	if want := []string{"ID", "Name", "Variants", "Attrs"}; !reflect.DeepEqual(st.Keys(), want) {
+		t.Errorf("keys: want=%v, got=%v", want, st.Keys())
+	}
+	if _, ok := st.Get("Secret"); ok {
+		t.Error("field with \"-\" tag should be skipped")
+	}
+	if _, ok := st.Get("internal"); ok {
+		t.Error("unexported field should be skipped")
+	}
+	if name, _ := st.Get("Name"); !reflect.DeepEqual(name, NewString("T-shirt")) {
+		t.Errorf("Name: got=%#v", name)
+	}
+
+	variants, _ := st.Get("Variants")
+	arr, ok := variants.(ArrayLike)
+	if !ok || arr.Type() != ArrayType || arr.Len() != 2 {
+		t.Fatalf("Variants: got=%#v", variants)
+	}
+	second, _ := arr.At(1)
+	sku, _ := second.(MapLike).Get("sku")
+	if !reflect.DeepEqual(sku, NewString("TS-M")) {
+		t.Errorf("Variants[1].sku: got=%#v", sku)
+	}
+	if _, ok := arr.At(2); ok {
+		t.Error("out of range access should fail")
+	}
+
+	attrs, _ := st.Get("Attrs")
+	color, _ := attrs.(MapLike).Get("color")
+	if !reflect.DeepEqual(color, NewString("red")) {
+		t.Errorf("Attrs.color: got=%#v", color)
+	}
+
+	// フィールドのメタデータは型ごとにキャッシュされる
+	other, _ := Wrap(Product{})
+	if other.(*LazyStruct).info != st.info {
+		t.Error("struct metadata should be cached per type")
+	}
+
+	// アクセス時に変換できない値は Error になる
+	wrapped, err := Wrap([]any{"a", func() {}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elem, _ := wrapped.(ArrayLike).At(1); elem.Type() != ErrorType {
+		t.Errorf("want error for unsupported element, got %#v", elem)
+	}
+
+	// 循環する値は Inspect で *RECURSION* になり、JSONValue はエラーを返す
+	type node struct {
+		Name string `gosmarty:"name"`
+		Next *node  `gosmarty:"next"`
+	}
+	n := &node{Name: "a"}
+	n.Next = n
+	cyclic, err := Wrap(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cyclic.Inspect(); got != "{name:a, next:*RECURSION*}" {
+		t.Errorf("cyclic Inspect: got=%s", got)
+	}
+	if _, err := JSONValue(cyclic); err == nil || !strings.Contains(err.Error(), "cycle") {
+		t.Errorf("cyclic JSONValue: want cycle error, got %v", err)
+	}
+	// 同じ値を兄弟として参照するだけなら循環ではない
+	shared := &node{Name: "s"}
+	pair, _ := Wrap([]*node{shared, shared})
+	if got := pair.Inspect(); got != "[{name:s, next:null}, {name:s, next:null}]" {
+		t.Errorf("shared Inspect: got=%s", got)
+	}
+	v, err := JSONValue(pair)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := json.Marshal(v); string(b) != `[{"name":"s","next":null},{"name":"s","next":null}]` {
+		t.Errorf("shared JSONValue: got=%s", b)
+	}
+
+	if _, err := Wrap(map[float64]string{1: "a"}); err == nil {
+		t.Error("want error for unsupported map key type")
+	}
+
+	// 整数のキーは PHP の配列のようにキーの昇順の OrderedMap になる
+	obj, err = Wrap(map[int]string{10: "c", -1: "a", 2: "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := orderedMap("-1", NewString("a"), "2", NewString("b"), "10", NewString("c")); !reflect.DeepEqual(obj, want) {
+		t.Errorf("map[int]string: want=%s, got=%s", want.Inspect(), obj.Inspect())
+	}
+	obj, err = NewObjectFromAny(map[uint8][]int{3: {1}, 1: nil})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := obj.Inspect(); got != "{1:[], 3:[1]}" {
+		t.Errorf("map[uint8][]int: got=%s", got)
+	}
+}
+
+func TestNumeric(t *testing.T) {
+	t.Parallel()
+
+	dec := func(s string) Object {
+		d, ok := ParseDecimal(s)
+		if !ok {
+			t.Fatalf("ParseDecimal(%q) failed", s)
+		}
+		return d
+	}
+	bigID, _ := new(big.Int).SetString("9007199254740993", 10) // 2^53 + 1
+
+	tests := []struct {
+		name        string
+		left, right Object
+		sum         string
+		sumType     ObjectType
+		diff        string
+		order       int
+	}{
+		{name: "integers", left: NewInteger(3), right: NewInteger(10), sum: "13", sumType: IntegerType, diff: "-7", order: -1},
+		{name: "beyond float64", left: &Integer{Value: bigID}, right: NewInteger(1), sum: "9007199254740994", sumType: IntegerType, diff: "9007199254740992", order: 1},
+		{name: "decimals", left: dec("0.1"), right: dec("0.2"), sum: "0.3", sumType: DecimalType, diff: "-0.1", order: -1},
+		{name: "integer and decimal", left: NewInteger(1200), right: dec("0.50"), sum: "1200.5", sumType: DecimalType, diff: "1199.5", order: 1},
+		{name: "float", left: &Number{Value: 1.5}, right: NewInteger(1), sum: "2.5", sumType: NumberType, diff: "0.5", order: 1},
+		{name: "equal", left: dec("2.0"), right: NewInteger(2), sum: "4", sumType: DecimalType, diff: "0", order: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sum, ok := AddNumbers(tt.left, tt.right)
+			if !ok || sum.Inspect() != tt.sum || sum.Type() != tt.sumType {
+				t.Errorf("AddNumbers: want %s (%s), got %#v", tt.sum, tt.sumType, sum)
+			}
+			diff, ok := SubNumbers(tt.left, tt.right)
+			if !ok || diff.Inspect() != tt.diff {
+				t.Errorf("SubNumbers: want %s, got %#v", tt.diff, diff)
+			}
+			if order, ok := CompareNumbers(tt.left, tt.right); !ok || order != tt.order {
+				t.Errorf("CompareNumbers: want %d, got %d", tt.order, order)
+			}
+		})
+	}
+
+	if got := (&Decimal{Value: big.NewRat(1, 3)}).Inspect(); got != "0.3333333333333333" {
+		t.Errorf("1/3: got %s", got)
+	}
+	for f, want := range map[float64]string{1500000: "1500000", 0.1: "0.1", 1e-7: "0.0000001", -2.5: "-2.5"} {
+		if got := (&Number{Value: f}).Inspect(); got != want {
+			t.Errorf("Number(%v): want %s, got %s", f, want, got)
+		}
+	}
+	if _, ok := AddNumbers(NewInteger(1), NewString("1")); ok {
+		t.Error("AddNumbers should not accept a string")
+	}
+}
+
+type sku string
+
+func (s sku) String() string { return "SKU-" + string(s) }
+
+type level int
+
+func (l level) MarshalText() ([]byte, error) {
+	if l < 0 {
+		return nil, errors.New("negative level")
+	}
+	return []byte([]string{"low", "high"}[l]), nil
+}
+
+type timestamps struct {
+	CreatedAt string `json:"created_at"`
+	UpdatedAt string `json:"updated_at,omitempty"`
+}
+
+type Audit struct {
+	Author string
+	Note   string `gosmarty:"note"`
+}
+
+type node struct {
+	Name string
+	Next *node
+}
+
+func TestStructConversion(t *testing.T) {
+	t.Parallel()
+
+	type Item struct {
+		timestamps
+		*Audit
+		ID       int      `json:"id"`
+		Name     string   `json:"name,omitempty"`
+		Tags     []string `json:"tags,omitempty"`
+		Code     sku
+		Level    level
+		Ignored  string `json:"-"`
+		Override string `gosmarty:"override" json:"ignored_name"`
+		internal string
+	}
+
+	item := Item{
+		timestamps: timestamps{CreatedAt: "2024-01-01"},
+		ID:         1,
+		Code:       "42",
+		Level:      1,
+		Override:   "o",
+		internal:   "x",
+	}
+
+	obj, err := NewObjectFromAny(item)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := orderedMap(
+		"created_at", NewString("2024-01-01"),
+		"id", NewInteger(1),
+		"Code", NewString("SKU-42"),
+		"Level", NewString("high"),
+		"override", NewString("o"),
+	)
+	if !reflect.DeepEqual(obj, want) {
+		t.Errorf("NewObjectFromAny: want=%s, got=%s", want.Inspect(), obj.Inspect())
+	}
+
+	item.Audit = &Audit{Author: "alice", Note: "n"}
+	item.Name = "widget"
+	obj, err = NewObjectFromAny(item)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"created_at", "Author", "note", "id", "name", "Code", "Level", "override"}; !reflect.DeepEqual(obj.(*OrderedMap).Keys(), want) {
+		t.Errorf("keys: want=%v, got=%v", want, obj.(*OrderedMap).Keys())
+	}
+
+	// 遅延評価でも同じ規則でフィールドを選ぶ
+	lazy, err := Wrap(item)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := lazy.(*LazyStruct)
+	if want := []string{"created_at", "Author", "note", "id", "name", "Code", "Level", "override"}; !reflect.DeepEqual(st.Keys(), want) {
+		t.Errorf("lazy keys: want=%v, got=%v", want, st.Keys())
+	}
+	if v, _ := st.Get("created_at"); !reflect.DeepEqual(v, NewString("2024-01-01")) {
+		t.Errorf("created_at: got=%#v", v)
+	}
+	if v, _ := st.Get("Code"); !reflect.DeepEqual(v, NewString("SKU-42")) {
+		t.Errorf("Code: got=%#v", v)
+	}
+	if _, ok := st.Get("updated_at"); ok {
+		t.Error("empty omitempty field should be absent")
+	}
+
+	// omitempty のゼロ値や nil の埋め込みポインタのフィールドは出力にも含まない
+	lazy, err = Wrap(Item{ID: 2, Code: "7"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := lazy.Inspect(), "{created_at:, id:2, Code:SKU-7, Level:low, override:}"; got != want {
+		t.Errorf("lazy Inspect: want=%s, got=%s", want, got)
+	}
+
+	// 同じ深さで名前が衝突するフィールドはどちらも使わない
+	type A struct{ Name string }
+	type B struct{ Name string }
+	type Conflict struct {
+		A
+		B
+		ID int
+	}
+	obj, err = NewObjectFromAny(Conflict{A: A{Name: "a"}, B: B{Name: "b"}, ID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"ID"}; !reflect.DeepEqual(obj.(*OrderedMap).Keys(), want) {
+		t.Errorf("conflict keys: want=%v, got=%v", want, obj.(*OrderedMap).Keys())
+	}
+
+	if _, err := NewObjectFromAny(Item{Level: -1}); err == nil {
+		t.Error("want error from MarshalText")
+	}
+
+	// 循環する値
+	n := &node{Name: "a"}
+	n.Next = &node{Name: "b", Next: n}
+	if _, err := NewObjectFromAny(n); err == nil || !strings.Contains(err.Error(), "cycle") {
+		t.Errorf("want cycle error, got %v", err)
+	}
+	m := map[string]any{}
+	m["self"] = m
+	if _, err := NewObjectFromAny(m); err == nil {
+		t.Error("want cycle error for map")
+	}
+	shared := &node{Name: "shared"}
+	if _, err := NewObjectFromAny([]*node{shared, shared}); err != nil {
+		t.Errorf("shared pointers are not cycles: %v", err)
+	}
+	wrapped, err := Wrap(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next, _ := wrapped.(MapLike).Get("Next")
+	back, _ := next.(MapLike).Get("Next")
+	if name, _ := back.(MapLike).Get("Name"); !reflect.DeepEqual(name, NewString("a")) {
+		t.Errorf("Wrap should follow cycles lazily, got=%#v", name)
+	}
+}
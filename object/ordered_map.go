@@ -0,0 +1,183 @@
+package object
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"slices"
+	"sort"
+	"strconv"
+)
+
+// OrderedMap は PHP の配列のように、挿入した順序を保つマップです。
+//
+// キーは文字列で保持しますが、"10" や "-1" のような正規の10進整数の文字列は
+// PHP と同じく整数のキーとして扱い、{foreach} のキー変数には Integer が渡されます。
+type OrderedMap struct {
+	keys   []string
+	values map[string]Object
+	// Append で使う次の整数のキー
+	nextIndex int64
+}
+
+var _ MapLike = (*OrderedMap)(nil)
+
+func NewOrderedMap() *OrderedMap {
+	return &OrderedMap{values: map[string]Object{}}
+}
+
+func (m *OrderedMap) Type() ObjectType {
+	return MapType
+}
+
+func (m *OrderedMap) Inspect() string {
+	return inspectMapLike(m, m.keys)
+}
+
+func (m *OrderedMap) Get(key string) (Object, bool) {
+	val, ok := m.values[key]
+	return val, ok
+}
+
+// Keys はキーを挿入した順に返します。
+func (m *OrderedMap) Keys() []string {
+	return m.keys
+}
+
+func (m *OrderedMap) Len() int {
+	return len(m.keys)
+}
+
+// Set は値を設定します。既にあるキーの場合は順序を変えずに値を置き換えます。
+func (m *OrderedMap) Set(key string, val Object) {
+	if _, ok := m.values[key]; !ok {
+		m.keys = append(m.keys, key)
+		if i, ok := intKey(key); ok && i >= m.nextIndex {
+			m.nextIndex = i + 1
+		}
+	}
+	m.values[key] = val
+}
+
+// Append は PHP の $a[] = $val と同じく、最大の整数のキーの次のキーで値を追加します。
+func (m *OrderedMap) Append(val Object) {
+	m.Set(strconv.FormatInt(m.nextIndex, 10), val)
+}
+
+// MapKey はマップのキーを、PHP と同じ規則で Integer か String に変換します。
+func MapKey(key string) Object {
+	if i, ok := intKey(key); ok {
+		return NewInteger(i)
+	}
+	return NewString(key)
+}
+
+// intKey は key が正規の10進整数の文字列 (先頭に0や+が付かない) であれば整数を返します。
+func intKey(key string) (int64, bool) {
+	i, err := strconv.ParseInt(key, 10, 64)
+	if err != nil || strconv.FormatInt(i, 10) != key {
+		return 0, false
+	}
+	return i, true
+}
+
+// OrderedKeys は m のキーを、m が順序を持つ場合はその順に、持たない場合は辞書順に返します。
+// OrderedMap は挿入順、LazyStruct はフィールドの宣言順を保ちます。
+func OrderedKeys(m MapLike) []string {
+	switch m.(type) {
+	case *OrderedMap, *LazyStruct:
+		return m.Keys()
+	}
+	keys := slices.Clone(m.Keys())
+	sort.Strings(keys)
+	return keys
+}
+
+// MarshalJSON はキーを挿入した順に並べたJSONのオブジェクトを返します。
+func (m *OrderedMap) MarshalJSON() ([]byte, error) {
+	v, err := JSONValue(m)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+// FromJSON はJSONをオブジェクトに変換します。
+// オブジェクトはキーの順序を保つ OrderedMap に、数値は Integer か Decimal になります。
+func FromJSON(data []byte) (Object, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	obj, err := decodeJSON(dec)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("invalid JSON: unexpected data after top-level value")
+	}
+	return obj, nil
+}
+
+func decodeJSON(dec *json.Decoder) (Object, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch tok := tok.(type) {
+	case json.Delim:
+		switch tok {
+		case '{':
+			m := NewOrderedMap()
+			for dec.More() {
+				keyTok, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				val, err := decodeJSON(dec)
+				if err != nil {
+					return nil, err
+				}
+				m.Set(keyTok.(string), val)
+			}
+			if _, err := dec.Token(); err != nil { // '}'
+				return nil, err
+			}
+			return m, nil
+		case '[':
+			arr := &Array{Value: []Object{}}
+			for dec.More() {
+				val, err := decodeJSON(dec)
+				if err != nil {
+					return nil, err
+				}
+				arr.Value = append(arr.Value, val)
+			}
+			if _, err := dec.Token(); err != nil { // ']'
+				return nil, err
+			}
+			return arr, nil
+		}
+		return nil, fmt.Errorf("invalid JSON: unexpected %v", tok)
+	case json.Number:
+		if i, ok := new(big.Int).SetString(tok.String(), 10); ok {
+			return &Integer{Value: i}, nil
+		}
+		if d, ok := ParseDecimal(tok.String()); ok {
+			return d, nil
+		}
+		f, err := tok.Float64()
+		if err != nil {
+			return nil, err
+		}
+		return &Number{Value: f}, nil
+	case string:
+		return NewString(tok), nil
+	case bool:
+		return NewBool(tok), nil
+	case nil:
+		return NULL, nil
+	default:
+		return nil, fmt.Errorf("invalid JSON: unexpected %v", tok)
+	}
+}
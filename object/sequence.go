@@ -0,0 +1,91 @@
+package object
+
+import (
+	"iter"
+	"reflect"
+)
+
+// Sequence は iter.Seq, iter.Seq2 やチャネルを包み、{foreach} で走査されるときに初めて値を読み出すオブジェクトです。
+//
+// データベースのカーソルのような値をスライスに展開せずにテンプレートへ流し込めます。
+// 要素の数は走査するまで分からないため、$smarty.foreach や {$item@total} の total と @count の結果は NULL になります。
+// {$item@last} を参照した場合は、最後の要素かどうかを判定するために次の要素を1つ先に読み出します。
+// チャネルや一度しか走査できないイテレータは、2回目の走査で要素を返しません。
+type Sequence struct {
+	value reflect.Value
+}
+
+func (s *Sequence) Type() ObjectType {
+	return SequenceType
+}
+
+func (s *Sequence) Inspect() string {
+	return "<" + s.value.Type().String() + ">"
+}
+
+// Value は包んでいるGoの値を返します。
+func (s *Sequence) Value() reflect.Value {
+	return s.value
+}
+
+// All はキーと値の組を順に返します。
+// iter.Seq とチャネルのキーは0から始まる Integer、iter.Seq2 のキーは1つ目の値です。
+// オブジェクトに変換できない値は Error として渡します。
+func (s *Sequence) All() iter.Seq2[Object, Object] {
+	return func(yield func(Object, Object) bool) {
+		v := s.value
+		if v.Kind() == reflect.Chan {
+			for i := 0; ; i++ {
+				elem, ok := v.Recv()
+				if !ok || !yield(NewInteger(i), mustWrap(elem)) {
+					return
+				}
+			}
+		} else if v.Type().In(0).NumIn() == 1 {
+			i := 0
+			yieldFn := reflect.MakeFunc(v.Type().In(0), func(args []reflect.Value) []reflect.Value {
+				ok := yield(NewInteger(i), mustWrap(args[0]))
+				i++
+				return []reflect.Value{reflect.ValueOf(ok)}
+			})
+			v.Call([]reflect.Value{yieldFn})
+			return
+		}
+
+		yieldFn := reflect.MakeFunc(v.Type().In(0), func(args []reflect.Value) []reflect.Value {
+			ok := yield(mustWrap(args[0]), mustWrap(args[1]))
+			return []reflect.Value{reflect.ValueOf(ok)}
+		})
+		v.Call([]reflect.Value{yieldFn})
+	}
+}
+
+// newSequence は rv が iter.Seq, iter.Seq2 と同じ形の関数か、受信できるチャネルであれば Sequence を返します。
+func newSequence(rv reflect.Value) (*Sequence, bool) {
+	if rv.IsNil() {
+		return nil, false
+	}
+	t := rv.Type()
+	switch t.Kind() {
+	case reflect.Chan:
+		if t.ChanDir()&reflect.RecvDir == 0 {
+			return nil, false
+		}
+		return &Sequence{value: rv}, true
+	case reflect.Func:
+		// func(yield func(V) bool) または func(yield func(K, V) bool)
+		if t.NumIn() != 1 || t.NumOut() != 0 || t.IsVariadic() {
+			return nil, false
+		}
+		yield := t.In(0)
+		if yield.Kind() != reflect.Func || yield.NumOut() != 1 || yield.Out(0).Kind() != reflect.Bool || yield.IsVariadic() {
+			return nil, false
+		}
+		if n := yield.NumIn(); n != 1 && n != 2 {
+			return nil, false
+		}
+		return &Sequence{value: rv}, true
+	default:
+		return nil, false
+	}
+}
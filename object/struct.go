@@ -0,0 +1,236 @@
+package object
+
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// 構造体の変換の規則
+//
+//   - 非公開のフィールドと関数のフィールドは無視します。
+//   - 埋め込まれた構造体のフィールドは、Goの昇格の規則と同じく外側の構造体のフィールドとして扱います。
+//     同じ深さに同じ名前のフィールドが複数ある場合は、タグで名前を付けたフィールドが1つだけならそれを使い、
+//     それ以外はどちらも無視します。
+//   - フィールド名は gosmarty タグ、json タグ、フィールド名の順に決まります。"-" のフィールドは無視します。
+//   - タグに omitempty が付いたフィールドは、ゼロ値であれば存在しないものとして扱います。
+//   - encoding.TextMarshaler か fmt.Stringer を実装した値は文字列に変換します。
+
+// structField はテンプレートから見た構造体のフィールドです。
+type structField struct {
+	name      string
+	index     []int // reflect.Value.FieldByIndex に渡すフィールド番号
+	omitEmpty bool
+	tagged    bool
+}
+
+// structInfo は構造体の型ごとのフィールドのメタデータです。
+type structInfo struct {
+	names  []string                // テンプレートから見たフィールド名 (宣言順)
+	fields map[string]*structField // フィールド名 -> フィールド
+	// 値によってフィールドが存在しない場合がある (omitempty や nil の埋め込みポインタ)
+	dynamic bool
+}
+
+var structInfoCache sync.Map // reflect.Type -> *structInfo
+
+// structInfoOf は構造体のフィールドのメタデータを返します。型ごとに一度だけ計算されます。
+func structInfoOf(rt reflect.Type) *structInfo {
+	if info, ok := structInfoCache.Load(rt); ok {
+		return info.(*structInfo)
+	}
+
+	info := &structInfo{fields: make(map[string]*structField)}
+	for _, field := range structFields(rt) {
+		info.names = append(info.names, field.name)
+		info.fields[field.name] = field
+		if field.omitEmpty || len(field.index) > 1 {
+			info.dynamic = true
+		}
+	}
+
+	actual, _ := structInfoCache.LoadOrStore(rt, info)
+	return actual.(*structInfo)
+}
+
+// structFields は埋め込まれた構造体をたどり、テンプレートから見えるフィールドを宣言順に返します。
+func structFields(rt reflect.Type) []*structField {
+	type embedded struct {
+		typ   reflect.Type
+		index []int
+	}
+
+	var fields []*structField
+	visited := map[reflect.Type]bool{}
+	hidden := map[string]bool{}
+	current := []embedded{{typ: rt}}
+	for len(current) > 0 {
+		var next []embedded
+		// この深さで見つかったフィールド
+		var level []*structField
+		for _, e := range current {
+			if visited[e.typ] {
+				continue
+			}
+			visited[e.typ] = true
+
+			for i := range e.typ.NumField() {
+				f := e.typ.Field(i)
+				index := append(slices.Clone(e.index), i)
+
+				name, omitEmpty, tagged := fieldName(f)
+				if name == "-" {
+					continue
+				}
+
+				ft := f.Type
+				if ft.Kind() == reflect.Ptr {
+					ft = ft.Elem()
+				}
+				if f.Anonymous && !tagged && ft.Kind() == reflect.Struct {
+					// 非公開の型でも、公開されたフィールドは昇格する
+					next = append(next, embedded{typ: ft, index: index})
+					continue
+				}
+				if !f.IsExported() || f.Type.Kind() == reflect.Func {
+					continue
+				}
+				level = append(level, &structField{name: name, index: index, omitEmpty: omitEmpty, tagged: tagged})
+			}
+		}
+
+		// 浅いフィールドが優先される
+		for _, field := range level {
+			if hidden[field.name] {
+				continue
+			}
+			if dominant, ok := dominantField(level, field.name); ok && dominant == field {
+				fields = append(fields, field)
+			}
+		}
+		for _, field := range level {
+			hidden[field.name] = true
+		}
+		current = next
+	}
+
+	// 埋め込みの深さに関係なく宣言順に並べる
+	slices.SortStableFunc(fields, func(a, b *structField) int {
+		return slices.Compare(a.index, b.index)
+	})
+	return fields
+}
+
+// dominantField は同じ深さにある同名のフィールドのうち、使うべきフィールドを返します。
+func dominantField(level []*structField, name string) (*structField, bool) {
+	var candidates []*structField
+	for _, field := range level {
+		if field.name == name {
+			candidates = append(candidates, field)
+		}
+	}
+	if len(candidates) == 1 {
+		return candidates[0], true
+	}
+	var tagged []*structField
+	for _, field := range candidates {
+		if field.tagged {
+			tagged = append(tagged, field)
+		}
+	}
+	if len(tagged) == 1 {
+		return tagged[0], true
+	}
+	return nil, false
+}
+
+// fieldName は gosmarty タグ、json タグ、フィールド名の順にテンプレートから見た名前を決めます。
+func fieldName(f reflect.StructField) (name string, omitEmpty, tagged bool) {
+	for _, key := range []string{"gosmarty", "json"} {
+		tag, ok := f.Tag.Lookup(key)
+		if !ok {
+			continue
+		}
+		if tag == "-" {
+			return "-", false, true
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		omitEmpty = slices.Contains(strings.Split(opts, ","), "omitempty")
+		if name != "" {
+			return name, omitEmpty, true
+		}
+		return f.Name, omitEmpty, false
+	}
+	return f.Name, false, false
+}
+
+// fieldValue は構造体の値 rv から field の値を取り出します。
+// nil の埋め込みポインタを経由する場合や、omitempty でゼロ値の場合は false を返します。
+func fieldValue(rv reflect.Value, field *structField) (reflect.Value, bool) {
+	val, err := rv.FieldByIndexErr(field.index)
+	if err != nil {
+		return reflect.Value{}, false
+	}
+	if field.omitEmpty && isEmptyValue(val) {
+		return reflect.Value{}, false
+	}
+	return val, true
+}
+
+// addressable は rv をアドレスを取れる値にします。
+// 取れない場合は rv のコピーを返します。
+func addressable(rv reflect.Value) reflect.Value {
+	if rv.CanAddr() {
+		return rv
+	}
+	v := reflect.New(rv.Type()).Elem()
+	v.Set(rv)
+	return v
+}
+
+// isEmptyValue は encoding/json の omitempty と同じ規則でゼロ値かどうかを判定します。
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64,
+		reflect.Interface, reflect.Pointer:
+		return v.IsZero()
+	default:
+		return false
+	}
+}
+
+// textValue は encoding.TextMarshaler か fmt.Stringer を実装した値を文字列に変換します。
+// 実装していない場合は false を返します。
+func textValue(i any) (Object, bool, error) {
+	switch v := i.(type) {
+	case encoding.TextMarshaler:
+		if isNilPointer(v) {
+			return NULL, true, nil
+		}
+		text, err := v.MarshalText()
+		if err != nil {
+			return nil, true, fmt.Errorf("failed to marshal %T as text: %w", i, err)
+		}
+		return NewString(string(text)), true, nil
+	case fmt.Stringer:
+		if isNilPointer(v) {
+			return NULL, true, nil
+		}
+		return NewString(v.String()), true, nil
+	default:
+		return nil, false, nil
+	}
+}
+
+func isNilPointer(i any) bool {
+	rv := reflect.ValueOf(i)
+	return rv.Kind() == reflect.Ptr && rv.IsNil()
+}
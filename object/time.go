@@ -1,6 +1,7 @@
 package object
 
 import (
+	"math"
 	"time"
 )
 
@@ -13,9 +14,84 @@ func NewTime(t time.Time) *Time {
 }
 
 func (s *Time) Type() ObjectType {
-	return StringType
+	return TimeType
 }
 
 func (s *Time) Inspect() string {
 	return s.Value.Format(time.RFC3339)
 }
+
+// Field は {$t.year} のような時刻のサブフィールドを、時刻が持つタイムゾーンで返します。
+// テンプレートから参照した場合は、date_format と同じくエンジンのタイムゾーンに変換してから求めます。
+func (s *Time) Field(name string) (Object, bool) {
+	var v int
+	switch name {
+	case "year":
+		v = s.Value.Year()
+	case "month":
+		v = int(s.Value.Month())
+	case "day":
+		v = s.Value.Day()
+	case "hour":
+		v = s.Value.Hour()
+	case "minute":
+		v = s.Value.Minute()
+	case "second":
+		v = s.Value.Second()
+	case "nanosecond":
+		v = s.Value.Nanosecond()
+	case "weekday":
+		// 0 (日曜日) から 6 (土曜日)
+		v = int(s.Value.Weekday())
+	case "yearday":
+		v = s.Value.YearDay()
+	case "week":
+		_, v = s.Value.ISOWeek()
+	case "unix":
+		return NewInteger(s.Value.Unix()), true
+	default:
+		return nil, false
+	}
+	return NewInteger(v), true
+}
+
+// Duration は2つの時刻の差や、時刻に加算する期間を表します。
+type Duration struct {
+	Value time.Duration
+}
+
+func NewDuration(d time.Duration) *Duration {
+	return &Duration{Value: d}
+}
+
+func (d *Duration) Type() ObjectType {
+	return DurationType
+}
+
+func (d *Duration) Inspect() string {
+	return d.Value.String()
+}
+
+// Field は {$d.hours} のような期間の単位ごとの値を返します。
+func (d *Duration) Field(name string) (Object, bool) {
+	switch name {
+	case "days":
+		return durationUnits(d.Value.Hours() / 24), true
+	case "hours":
+		return durationUnits(d.Value.Hours()), true
+	case "minutes":
+		return durationUnits(d.Value.Minutes()), true
+	case "seconds":
+		return durationUnits(d.Value.Seconds()), true
+	default:
+		return nil, false
+	}
+}
+
+// durationUnits は割り切れる場合は Integer を、端数がある場合は Number を返します。
+func durationUnits(f float64) Object {
+	if f == math.Trunc(f) && math.Abs(f) < 1<<53 {
+		return NewInteger(int64(f))
+	}
+	return &Number{Value: f}
+}
@@ -2,7 +2,10 @@ package parser
 
 import (
 	"fmt"
+	"slices"
 	"strconv"
+	"strings"
+	"unicode"
 
 	"github.com/szks-repo/gosmarty/ast"
 	"github.com/szks-repo/gosmarty/lexer"
@@ -23,17 +26,22 @@ const (
 	OR
 	AND
 	COMPARISON
+	SUM
 )
 
 var precedences = map[token.TokenType]int{
-	token.OR:    OR,
-	token.AND:   AND,
-	token.EQ:    COMPARISON,
-	token.NOTEQ: COMPARISON,
-	token.LT:    COMPARISON,
-	token.LTE:   COMPARISON,
-	token.GT:    COMPARISON,
-	token.GTE:   COMPARISON,
+	token.OR:           OR,
+	token.AND:          AND,
+	token.EQ:           COMPARISON,
+	token.NOTEQ:        COMPARISON,
+	token.IDENTICAL:    COMPARISON,
+	token.NOTIDENTICAL: COMPARISON,
+	token.LT:           COMPARISON,
+	token.LTE:          COMPARISON,
+	token.GT:           COMPARISON,
+	token.GTE:          COMPARISON,
+	token.PLUS:         SUM,
+	token.MINUS:        SUM,
 }
 
 func New(l *lexer.Lexer) *Parser {
@@ -94,8 +102,13 @@ func (p *Parser) parseTextNode() *ast.TextNode {
 
 // parseTag は `{` の次のトークンを見て、どの構文か判断し、パースを振り分ける
 func (p *Parser) parseTag() ast.Node {
+	if p.peekTokenIs(token.IDENT) && p.peekToken.Literal == "assign" {
+		return p.parseAssignTag()
+	}
+
 	switch p.peekToken.Type {
-	case token.DOLLAR:
+	case token.DOLLAR, token.LPAREN, token.IDENT, token.LBRACKET, token.STRING, token.INTERP:
+		// {format_price($p, "JPY")} のような関数呼び出しも式として扱う
 		return p.parseVariableTagWithPipeline()
 	// todo: consider this case
 	// case token.NUMBER:
@@ -154,6 +167,11 @@ func (p *Parser) parseIfTag() *ast.IfNode {
 	if cond == nil {
 		return nil
 	}
+	// {if $needle|in_array:$list} のように条件にも修飾子を使える
+	cond = p.parsePipeline(cond)
+	if cond == nil {
+		return nil
+	}
 	node.Condition = cond
 
 	// 2. {if ...} の閉じ '}' を消費
@@ -179,6 +197,10 @@ func (p *Parser) parseIfTag() *ast.IfNode {
 		if cond == nil {
 			return nil
 		}
+		cond = p.parsePipeline(cond)
+		if cond == nil {
+			return nil
+		}
 
 		elseifNode.Condition = cond
 
@@ -343,6 +365,259 @@ func (p *Parser) parseForeachTag() *ast.ForeachNode {
 	return node
 }
 
+// parseAssignTag は {assign var="name" value=...} をパースする
+func (p *Parser) parseAssignTag() *ast.AssignNode {
+	// curTokenは '{'
+	p.nextToken() // '{' を消費 -> curTokenは 'assign'
+	node := &ast.AssignNode{Token: p.curToken}
+	p.nextToken() // 'assign' を消費 -> curTokenは最初の属性名
+
+	for !p.curTokenIs(token.RDELIM) && !p.curTokenIs(token.EOF) {
+		if !p.curTokenIs(token.IDENT) {
+			p.errors = append(p.errors, fmt.Sprintf("expected attribute name for assign, got %s", p.curToken.Type))
+			return nil
+		}
+
+		attrName := p.curToken.Literal
+		p.nextToken()
+
+		if !p.curTokenIs(token.ASSIGN) {
+			p.errors = append(p.errors, fmt.Sprintf("expected '=' after assign attribute %q", attrName))
+			return nil
+		}
+		p.nextToken()
+
+		switch attrName {
+		case "var":
+			// var="name" と var=name のどちらも受け付ける
+			if !p.curTokenIs(token.STRING) && !p.curTokenIs(token.IDENT) {
+				p.errors = append(p.errors, fmt.Sprintf("expected variable name in assign attribute, got %s", p.curToken.Type))
+				return nil
+			}
+			node.Name = p.curToken.Literal
+			p.nextToken()
+		case "value":
+			expr := p.parseExpression(LOWEST)
+			if expr == nil {
+				return nil
+			}
+			expr = p.parsePipeline(expr)
+			if expr == nil {
+				return nil
+			}
+			node.Value = expr
+		default:
+			p.errors = append(p.errors, fmt.Sprintf("unsupported assign attribute: %s", attrName))
+			return nil
+		}
+	}
+
+	if !p.curTokenIs(token.RDELIM) {
+		p.errors = append(p.errors, "expected RDELIM to close assign tag")
+		return nil
+	}
+	// '}' を消費
+	p.nextToken()
+
+	if node.Name == "" {
+		p.errors = append(p.errors, "assign requires var attribute")
+		return nil
+	}
+	if node.Value == nil {
+		p.errors = append(p.errors, "assign requires value attribute")
+		return nil
+	}
+	return node
+}
+
+// parseArrayLiteral は '[' から ']'、または 'array(' から ')' までの配列リテラルをパースする
+// 要素は値だけ、または key => value の形で書ける
+func (p *Parser) parseArrayLiteral(end token.TokenType) ast.Node {
+	lit := &ast.ArrayLiteral{Token: p.curToken, Keys: []ast.Node{}, Values: []ast.Node{}}
+	if end == token.RPAREN {
+		p.nextToken() // 'array' を消費 -> curTokenは '('
+	}
+	p.nextToken() // '[' または '(' を消費
+
+	for !p.curTokenIs(end) {
+		var key ast.Node
+		value := p.parseExpression(LOWEST)
+		if value == nil {
+			return nil
+		}
+		if p.curTokenIs(token.DARROW) {
+			p.nextToken() // '=>' を消費
+			key = value
+			if value = p.parseExpression(LOWEST); value == nil {
+				return nil
+			}
+		}
+		lit.Keys = append(lit.Keys, key)
+		lit.Values = append(lit.Values, value)
+
+		if !p.curTokenIs(token.COMMA) {
+			break
+		}
+		p.nextToken() // ',' を消費 (末尾のカンマも許す)
+	}
+
+	if !p.curTokenIs(end) {
+		p.errors = append(p.errors, fmt.Sprintf("expected token to be %s, got %s instead", end, p.curToken.Type))
+		return nil
+	}
+	p.nextToken() // ']' または ')' を消費
+	return lit
+}
+
+// parseInterpolatedString はダブルクォートの文字列を、文字列の部分と埋め込まれた式に分けてパースする
+//
+//	"Hello $name"          -> 英数字とアンダースコアだけの変数名
+//	"/img/{$item.id}.png"  -> {...} の中は修飾子を含む任意の式
+//	"`$user.name`"         -> バッククォートの中は任意の式
+func (p *Parser) parseInterpolatedString() ast.Node {
+	tok := p.curToken
+	node := &ast.InterpolatedString{Token: tok}
+	src := []rune(tok.Literal)
+
+	var text strings.Builder
+	flush := func() {
+		if text.Len() > 0 {
+			node.Parts = append(node.Parts, &ast.StringLiteral{Token: tok, Value: text.String()})
+			text.Reset()
+		}
+	}
+
+	for i := 0; i < len(src); i++ {
+		ch := src[i]
+		switch {
+		case ch == '\\' && i+1 < len(src):
+			i++
+			switch src[i] {
+			case 'n':
+				text.WriteRune('\n')
+			case 't':
+				text.WriteRune('\t')
+			case 'r':
+				text.WriteRune('\r')
+			case '\\', '"', '$', '`':
+				text.WriteRune(src[i])
+			default:
+				text.WriteRune('\\')
+				text.WriteRune(src[i])
+			}
+		case ch == '$' && i+1 < len(src) && isVariableNameStart(src[i+1]):
+			end := i + 1
+			for end < len(src) && isVariableNameChar(src[end]) {
+				end++
+			}
+			flush()
+			node.Parts = append(node.Parts, &ast.Identifier{Token: tok, Value: string(src[i+1 : end])})
+			i = end - 1
+		case ch == '`':
+			end := slices.Index(src[i+1:], '`')
+			if end < 0 {
+				p.errors = append(p.errors, fmt.Sprintf("unterminated ` in string %q", tok.Literal))
+				return nil
+			}
+			end += i + 1
+			expr := p.parseEmbedded(src, i, end)
+			if expr == nil {
+				return nil
+			}
+			flush()
+			node.Parts = append(node.Parts, expr)
+			i = end
+		case ch == '{' && i+1 < len(src) && src[i+1] == '$':
+			end := matchingBrace(src, i)
+			if end < 0 {
+				p.errors = append(p.errors, fmt.Sprintf("unterminated { in string %q", tok.Literal))
+				return nil
+			}
+			expr := p.parseEmbedded(src, i, end)
+			if expr == nil {
+				return nil
+			}
+			flush()
+			node.Parts = append(node.Parts, expr)
+			i = end
+		default:
+			text.WriteRune(ch)
+		}
+	}
+	flush()
+
+	p.nextToken() // 文字列トークンを消費
+	return node
+}
+
+// parseEmbedded は文字列 src の start と end の間に埋め込まれた式を、修飾子を含む式としてパースする
+// src[start] の '{' か '`' を区切りとして、テンプレート上の位置を保ったまま読む
+func (p *Parser) parseEmbedded(str []rune, start, end int) ast.Node {
+	src := string(str[start+1 : end])
+	// 文字列の中身は開きの引用符の次の列から始まる
+	line, column := p.curToken.Line, p.curToken.Column+1
+	for _, ch := range str[:start] {
+		if ch == '\n' {
+			line++
+			column = 0
+		}
+		column++
+	}
+	sub := New(lexer.NewAt("{"+src+"}", line, column))
+	sub.nextToken() // '{' を消費
+
+	expr := sub.parseExpression(LOWEST)
+	if expr != nil {
+		expr = sub.parsePipeline(expr)
+	}
+	if expr != nil && !sub.curTokenIs(token.RDELIM) {
+		sub.errors = append(sub.errors, fmt.Sprintf("unexpected %s", sub.curToken.Type))
+	}
+	if len(sub.errors) > 0 || expr == nil {
+		for _, msg := range sub.errors {
+			p.errors = append(p.errors, fmt.Sprintf("in string %q: %s", src, msg))
+		}
+		return nil
+	}
+	return expr
+}
+
+// matchingBrace は src[start] の '{' に対応する '}' の位置を返す
+// 引用符の中の括弧は数えない。見つからなければ -1 を返す
+func matchingBrace(src []rune, start int) int {
+	depth := 0
+	var quote rune
+	for i := start; i < len(src); i++ {
+		ch := src[i]
+		switch {
+		case quote != 0:
+			if ch == '\\' {
+				i++
+			} else if ch == quote {
+				quote = 0
+			}
+		case ch == '"' || ch == '\'':
+			quote = ch
+		case ch == '{':
+			depth++
+		case ch == '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func isVariableNameStart(ch rune) bool {
+	return ch == '_' || unicode.IsLetter(ch)
+}
+
+func isVariableNameChar(ch rune) bool {
+	return isVariableNameStart(ch) || unicode.IsDigit(ch)
+}
+
 // parseBlockUntil は指定された終了トークンが見つかるまでノードをパースし続ける
 func (p *Parser) parseBlockUntil(endTokens ...token.TokenType) *ast.ListNode {
 	block := &ast.ListNode{Nodes: []ast.Node{}}
@@ -438,6 +713,38 @@ func (p *Parser) parseVariableTagWithPipeline() ast.Node {
 		return nil
 	}
 
+	left = p.parsePipeline(left)
+	if left == nil {
+		return nil
+	}
+
+	// {$var nofilter} は自動エスケープを無効にする
+	noFilter := false
+	if p.curTokenIs(token.IDENT) && p.curToken.Literal == "nofilter" {
+		noFilter = true
+		p.nextToken()
+	}
+
+	if !p.curTokenIs(token.RDELIM) {
+		p.errors = append(p.errors, fmt.Sprintf("expected RDELIM, got %s", p.curToken.Type))
+		return nil
+	}
+
+	// '}' を消費
+	p.nextToken()
+
+	return &ast.ActionNode{
+		Token: token.Token{
+			Type:    token.LDELIM,
+			Literal: "{",
+		},
+		Pipe:     left,
+		NoFilter: noFilter,
+	}
+}
+
+// parsePipeline は left に続く '|' 修飾子の連なりをパースする
+func (p *Parser) parsePipeline(left ast.Node) ast.Node {
 	// '|' が続く限りパイプラインを構築
 	for p.curTokenIs(token.PIPE) {
 		pipeToken := p.curToken
@@ -445,39 +752,44 @@ func (p *Parser) parseVariableTagWithPipeline() ast.Node {
 		// '|' を消費
 		p.nextToken()
 
+		// '@' は配列そのものに修飾子を適用する
+		applyToArray := false
+		if p.curTokenIs(token.AT) {
+			applyToArray = true
+			p.nextToken()
+		}
+
 		if !p.curTokenIs(token.IDENT) {
 			p.errors = append(p.errors, "expected modifier function name after '|'")
 			return nil
 		}
 
 		// 新しいPipeNodeを作成し、それまでの式を左辺に設定
-		left = &ast.PipeNode{
+		pipe := &ast.PipeNode{
 			Token: pipeToken,
 			Left:  left,
 			Function: &ast.Identifier{
 				Token: p.curToken,
 				Value: p.curToken.Literal,
 			},
+			ApplyToArray: applyToArray,
 		}
 		// 関数名を消費
 		p.nextToken()
-	}
-
-	if !p.curTokenIs(token.RDELIM) {
-		p.errors = append(p.errors, fmt.Sprintf("expected RDELIM, got %s", p.curToken.Type))
-		return nil
-	}
-
-	// '}' を消費
-	p.nextToken()
 
-	return &ast.ActionNode{
-		Token: token.Token{
-			Type:    token.LDELIM,
-			Literal: "{",
-		},
-		Pipe: left,
+		// ':' が続く限り修飾子の引数をパース
+		for p.curTokenIs(token.COLON) {
+			// ':' を消費
+			p.nextToken()
+			arg := p.parsePrimaryExpr()
+			if arg == nil {
+				return nil
+			}
+			pipe.Args = append(pipe.Args, arg)
+		}
+		left = pipe
 	}
+	return left
 }
 
 func (p *Parser) parsePrimaryExpr_backup() ast.Node {
@@ -541,6 +853,76 @@ func (p *Parser) parsePrimaryExpr() ast.Node {
 		p.nextToken() // 識別子を消費
 	case token.NUMBER:
 		left = p.parseNumberLiteral()
+	case token.MINUS:
+		// 負の数値リテラル (e.g., -1)
+		if !p.peekTokenIs(token.NUMBER) {
+			p.errors = append(p.errors, fmt.Sprintf("expected NUMBER after '-', got %s", p.peekToken.Type))
+			return nil
+		}
+		p.nextToken() // '-' を消費
+		num := p.parseNumberLiteral()
+		if num == nil {
+			return nil
+		}
+		lit := num.(*ast.NumberLiteral)
+		lit.Value = -lit.Value
+		lit.Token.Literal = "-" + lit.Token.Literal
+		left = lit
+	case token.STRING:
+		left = &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
+		p.nextToken() // 文字列トークンを消費
+	case token.INTERP:
+		left = p.parseInterpolatedString()
+		if left == nil {
+			return nil
+		}
+	case token.LBRACKET:
+		left = p.parseArrayLiteral(token.RBRACKET)
+		if left == nil {
+			return nil
+		}
+	case token.IDENT:
+		// PHP と同じく大文字と小文字を区別しない
+		switch strings.ToLower(p.curToken.Literal) {
+		case "true", "false":
+			left = &ast.BooleanLiteral{Token: p.curToken, Value: strings.EqualFold(p.curToken.Literal, "true")}
+			p.nextToken() // 'true' または 'false' を消費
+			return left
+		case "null":
+			left = &ast.NullLiteral{Token: p.curToken}
+			p.nextToken() // 'null' を消費
+			return left
+		}
+		if !p.peekTokenIs(token.LPAREN) {
+			p.errors = append(p.errors, fmt.Sprintf("unknown tag or function: %s", p.curToken.Literal))
+			return nil
+		}
+		// Smarty 2 の array(...) 形式
+		if p.curToken.Literal == "array" {
+			left = p.parseArrayLiteral(token.RPAREN)
+			if left == nil {
+				return nil
+			}
+			break
+		}
+		fn := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+		p.nextToken() // 関数名を消費 -> curTokenは '('
+		args := p.parseCallArguments()
+		if args == nil {
+			return nil
+		}
+		left = &ast.CallExpression{Token: fn.Token, Function: fn, Args: args}
+	case token.LPAREN:
+		p.nextToken() // '(' を消費
+		left = p.parseExpression(LOWEST)
+		if left == nil {
+			return nil
+		}
+		if !p.curTokenIs(token.RPAREN) {
+			p.errors = append(p.errors, fmt.Sprintf("expected token to be ), got %s instead", p.curToken.Type))
+			return nil
+		}
+		p.nextToken() // ')' を消費
 	default:
 		p.errors = append(p.errors, fmt.Sprintf("unexpected token for primary expression: %s", p.curToken.Type))
 		return nil
@@ -553,6 +935,27 @@ func (p *Parser) parsePrimaryExpr() ast.Node {
 			dotToken := p.curToken
 			p.nextToken() // '.' を消費
 
+			// {$arr.0} や {$map.$key} は [] によるインデックスアクセスとして扱う
+			switch p.curToken.Type {
+			case token.NUMBER:
+				index := p.parseNumberLiteral()
+				if index == nil {
+					return nil
+				}
+				left = &ast.IndexExpression{Token: dotToken, Left: left, Index: index}
+				continue
+			case token.DOLLAR:
+				p.nextToken() // '$' を消費
+				if !p.curTokenIs(token.IDENT) {
+					p.errors = append(p.errors, fmt.Sprintf("expected IDENT after '.$', got %s", p.curToken.Type))
+					return nil
+				}
+				index := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+				p.nextToken() // 識別子を消費
+				left = &ast.IndexExpression{Token: dotToken, Left: left, Index: index}
+				continue
+			}
+
 			if !isIdentLike(p.curToken.Type) {
 				p.errors = append(p.errors, fmt.Sprintf("expected IDENT-like token after '.', got %s", p.curToken.Type))
 				return nil
@@ -566,6 +969,53 @@ func (p *Parser) parsePrimaryExpr() ast.Node {
 			}
 			p.nextToken() // プロパティ識別子を消費
 
+		case token.ARROW:
+			arrowToken := p.curToken
+			p.nextToken() // '->' を消費
+
+			if !isIdentLike(p.curToken.Type) {
+				p.errors = append(p.errors, fmt.Sprintf("expected IDENT-like token after '->', got %s", p.curToken.Type))
+				return nil
+			}
+			name := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+			p.nextToken() // メソッド名・プロパティ名を消費
+
+			if !p.curTokenIs(token.LPAREN) {
+				// {$obj->prop} はフィールドアクセスとして扱う
+				left = &ast.FieldAccess{Token: arrowToken, Left: left, Right: name}
+				continue
+			}
+
+			args := p.parseCallArguments()
+			if args == nil {
+				return nil
+			}
+			left = &ast.MethodCall{
+				Token:    arrowToken,
+				Receiver: left,
+				Method:   name,
+				Args:     args,
+			}
+
+		case token.AT:
+			// {$item@index} は要素変数の直後にだけ書ける
+			item, ok := left.(*ast.Identifier)
+			if !ok {
+				return left
+			}
+			atToken := p.curToken
+			p.nextToken() // '@' を消費
+			if !p.curTokenIs(token.IDENT) {
+				p.errors = append(p.errors, fmt.Sprintf("expected loop property after '@', got %s", p.curToken.Type))
+				return nil
+			}
+			left = &ast.LoopProperty{
+				Token:    atToken,
+				Item:     item,
+				Property: &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal},
+			}
+			p.nextToken() // プロパティ名を消費
+
 		case token.LBRACKET: // ここを修正します
 			bracketToken := p.curToken
 			p.nextToken() // '[' を消費
@@ -595,8 +1045,38 @@ func (p *Parser) parsePrimaryExpr() ast.Node {
 			return left
 		}
 	}
+}
 
-	return left
+// parseCallArguments は '(' から ')' までのカンマ区切りの引数をパースする
+// 引数がない場合は空のスライスを、エラーの場合は nil を返す
+func (p *Parser) parseCallArguments() []ast.Node {
+	args := []ast.Node{}
+	p.nextToken() // '(' を消費
+
+	if p.curTokenIs(token.RPAREN) {
+		p.nextToken() // ')' を消費
+		return args
+	}
+
+	for {
+		arg := p.parseExpression(LOWEST)
+		if arg == nil {
+			return nil
+		}
+		args = append(args, arg)
+
+		if !p.curTokenIs(token.COMMA) {
+			break
+		}
+		p.nextToken() // ',' を消費
+	}
+
+	if !p.curTokenIs(token.RPAREN) {
+		p.errors = append(p.errors, fmt.Sprintf("expected token to be ), got %s instead", p.curToken.Type))
+		return nil
+	}
+	p.nextToken() // ')' を消費
+	return args
 }
 
 func (p *Parser) parseExpression(precedence int) ast.Node {
@@ -0,0 +1,167 @@
+package gosmarty
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/szks-repo/gosmarty/ast"
+	"github.com/szks-repo/gosmarty/modifier"
+	"github.com/szks-repo/gosmarty/object"
+)
+
+// scope はテンプレートの1回の実行ごとに作られる変数スコープです。
+//
+// 共有の Environment は読み取り専用として扱い、ループ変数などの書き込みは
+// 実行ごとのフレームのスタックに対して行います (コピーオンライト)。
+// そのため、1つの Environment を複数のゴルーチンから同時に使ってレンダリングできます。
+type scope struct {
+	ctx    context.Context
+	env    *Environment
+	engine *GoSmarty
+	frames []*frame
+	rc     *modifier.RenderContext
+
+	// 0 より大きい間は未定義の参照を報告しない (isset() や |default の左辺)
+	quiet    int
+	warnings []Warning
+}
+
+// frame は {foreach} などのブロックが持つローカル変数の集合です。
+type frame struct {
+	vars  map[string]object.Object
+	loops map[string]object.Object // $smarty.foreach.<name>
+	// {foreach} のフレームの場合、要素変数の名前とループの状態 ({$item@index})
+	item string
+	loop *loopState
+}
+
+func newScope(env *Environment, engine *GoSmarty) *scope {
+	if env == nil {
+		env = &Environment{vars: map[string]object.Object{}}
+	}
+	sc := &scope{
+		ctx:    context.Background(),
+		env:    env,
+		engine: engine,
+	}
+	// テンプレート全体のフレーム ({assign} で代入した変数を持つ)
+	sc.push()
+	return sc
+}
+
+// GetVar はフレームを内側から順に探し、見つからなければ共有の Environment を探します。
+func (s *scope) GetVar(name string) (object.Object, bool) {
+	for i := len(s.frames) - 1; i >= 0; i-- {
+		if obj, ok := s.frames[i].vars[name]; ok {
+			return obj, true
+		}
+	}
+	return s.env.GetVar(name)
+}
+
+// SetVar は変数に値を代入します。
+// 内側のフレームに同じ名前の変数があればそれを書き換え、なければテンプレート全体のフレームに代入します。
+// 共有の Environment は書き換えません。
+func (s *scope) SetVar(name string, obj object.Object) {
+	for i := len(s.frames) - 1; i > 0; i-- {
+		if _, ok := s.frames[i].vars[name]; ok {
+			s.frames[i].vars[name] = obj
+			return
+		}
+	}
+	s.frames[0].vars[name] = obj
+}
+
+// push は新しいフレームを積みます。
+func (s *scope) push() *frame {
+	f := &frame{
+		vars:  map[string]object.Object{},
+		loops: map[string]object.Object{},
+	}
+	s.frames = append(s.frames, f)
+	return f
+}
+
+// pop は最も内側のフレームを取り除きます。
+func (s *scope) pop() {
+	s.frames = s.frames[:len(s.frames)-1]
+}
+
+// foreachLoops は $smarty.foreach を、内側のフレームを優先して組み立てます。
+func (s *scope) foreachLoops() *object.Map {
+	loops := &object.Map{Value: map[string]object.Object{}}
+	for _, f := range s.frames {
+		for name, state := range f.loops {
+			loops.Value[name] = state
+		}
+	}
+	return loops
+}
+
+// loopProperty は要素変数 item の {foreach} のループの状態から prop を返します。
+// 同じ名前の要素変数を持つループが入れ子になっている場合は、内側のループを優先します。
+func (s *scope) loopProperty(item, prop string) (object.Object, bool) {
+	for i := len(s.frames) - 1; i >= 0; i-- {
+		f := s.frames[i]
+		if f.loop != nil && f.item == item {
+			return f.loop.Get(prop)
+		}
+	}
+	return nil, false
+}
+
+// undefined は未定義の変数や修飾子の参照を、エンジンの ErrorLevel に従って報告します。
+// ErrorLevelStrict の場合は位置を含む object.Error を、それ以外は NULL を返します。
+func (s *scope) undefined(pos string, format string, args ...any) object.Object {
+	if s.quiet > 0 {
+		return NULL
+	}
+	switch s.engine.level() {
+	case ErrorLevelWarn:
+		s.warnings = append(s.warnings, Warning{Pos: pos, Message: fmt.Sprintf(format, args...)})
+	case ErrorLevelStrict:
+		return object.NewError("%s: %s", pos, fmt.Sprintf(format, args...))
+	}
+	return NULL
+}
+
+// quietly は未定義の参照を報告せずに node を評価します。
+func (s *scope) quietly(node ast.Node) object.Object {
+	s.quiet++
+	defer func() { s.quiet-- }()
+	return eval(node, s)
+}
+
+// truthy は {if} や and, or で obj が真かどうかを判定します。
+// エンジンが ComparisonPHP の場合は PHP と同じく "0" を偽として扱います。
+func (s *scope) truthy(obj object.Object) bool {
+	if s.engine.phpComparison() {
+		return phpTruthy(obj)
+	}
+	return isTruthy(obj)
+}
+
+// renderContext は修飾子に渡す RenderContext を返します。
+// 実行ごとに1度だけ作成します。
+func (s *scope) renderContext() *modifier.RenderContext {
+	if s.rc != nil {
+		return s.rc
+	}
+	s.rc = modifier.Background()
+	s.rc.Context = s.ctx
+	s.rc.GetVar = s.GetVar
+	if s.engine != nil {
+		s.rc.Location = s.engine.location
+		s.rc.Locale = s.engine.locale
+		s.rc.Charset = s.engine.charset
+		s.rc.EscapeHTML = s.engine.escapeHTML || s.engine.contextualEscape
+	}
+	s.rc.Equal = func(a, b object.Object) bool {
+		if s.engine.phpComparison() {
+			return looseEqual(a, b)
+		}
+		return objectsEqual(a, b)
+	}
+	s.rc.Identical = identical
+	return s.rc
+}
@@ -1,9 +1,18 @@
 package token
 
+import "fmt"
+
 // Token は字句解析器(Lexer)が生成するトークンを表す構造体です。
 type Token struct {
 	Type    TokenType
 	Literal string
+	Line    int // トークンの先頭の行 (1から始まる)
+	Column  int // トークンの先頭の列 (1から始まる、文字単位)
+}
+
+// Pos はエラーメッセージ用に "行:列" の形式で位置を返します。
+func (t Token) Pos() string {
+	return fmt.Sprintf("%d:%d", t.Line, t.Column)
 }
 
 // TokenType はトークンの種類を表す文字列です。
@@ -26,15 +35,23 @@ const (
 	IDENT    = "IDENT" // 変数名など (例: foo, bar)
 	DOLLAR   = "$"
 	PIPE     = "|"
+	AT       = "@"
+	COLON    = ":"
+	COMMA    = ","
+	ARROW    = "->"
 	DOT      = "."
 	LBRACKET = "["
 	RBRACKET = "]"
+	LPAREN   = "("
+	RPAREN   = ")"
 	STRING   = "STRING" // "foo" or 'bar'
+	INTERP   = "INTERP" // "Hello $name" (変数が埋め込まれたダブルクォートの文字列)
 	NUMBER   = "NUMBER" // 12345
 	TEXT     = "TEXT"   // デリミタの外にあるプレーンなテキスト
 
 	// 演算子
 	ASSIGN   = "="
+	DARROW   = "=>"
 	PLUS     = "+"
 	MINUS    = "-"
 	BANG     = "!"
@@ -43,12 +60,15 @@ const (
 
 	EQ    = "=="
 	NOTEQ = "!="
-	LT    = "<"
-	LTE   = "<="
-	GT    = ">"
-	GTE   = ">="
-	AND   = "and"
-	OR    = "or"
+	// 型も値も等しい (PHP の === と !==)
+	IDENTICAL    = "==="
+	NOTIDENTICAL = "!=="
+	LT           = "<"
+	LTE          = "<="
+	GT           = ">"
+	GTE          = ">="
+	AND          = "and"
+	OR           = "or"
 
 	IF          = "if"
 	ELSE        = "else"
@@ -0,0 +1,114 @@
+package gosmarty
+
+import (
+	"fmt"
+
+	"github.com/szks-repo/gosmarty/ast"
+	"github.com/szks-repo/gosmarty/modifier"
+)
+
+// validate はテンプレートで使われている修飾子と関数がエンジンに登録されているかを調べ、
+// 見つかった問題を "行:列: メッセージ" の形で返します。
+// 引数の数を宣言している修飾子と関数は、引数の数も調べます。
+func (gsm *GoSmarty) validate(tree *ast.Tree) []string {
+	v := &validator{gsm: gsm}
+	v.walk(tree.Root)
+	return v.errors
+}
+
+type validator struct {
+	gsm    *GoSmarty
+	errors []string
+}
+
+func (v *validator) errorf(pos string, format string, args ...any) {
+	v.errors = append(v.errors, pos+": "+fmt.Sprintf(format, args...))
+}
+
+func (v *validator) walk(node ast.Node) {
+	switch node := node.(type) {
+	case *ast.ListNode:
+		if node == nil {
+			return
+		}
+		for _, child := range node.Nodes {
+			v.walk(child)
+		}
+	case *ast.ActionNode:
+		v.walk(node.Pipe)
+	case *ast.IfNode:
+		v.walk(node.Condition)
+		v.walk(node.Consequence)
+		for _, elseIf := range node.ElseIfs {
+			v.walk(elseIf.Condition)
+			v.walk(elseIf.Consequence)
+		}
+		v.walk(node.Alternative)
+	case *ast.ForeachNode:
+		v.walk(node.Source)
+		v.walk(node.Body)
+		v.walk(node.Alternative)
+	case *ast.AssignNode:
+		v.walk(node.Value)
+	case *ast.PipeNode:
+		v.walk(node.Left)
+		v.walkAll(node.Args)
+		v.checkModifier(node)
+	case *ast.CallExpression:
+		v.walkAll(node.Args)
+		v.checkFunction(node)
+	case *ast.MethodCall:
+		v.walk(node.Receiver)
+		v.walkAll(node.Args)
+	case *ast.FieldAccess:
+		v.walk(node.Left)
+	case *ast.IndexExpression:
+		v.walk(node.Left)
+		v.walk(node.Index)
+	case *ast.InfixExpression:
+		v.walk(node.Left)
+		v.walk(node.Right)
+	case *ast.ArrayLiteral:
+		v.walkAll(node.Keys)
+		v.walkAll(node.Values)
+	case *ast.InterpolatedString:
+		v.walkAll(node.Parts)
+	}
+}
+
+func (v *validator) walkAll(nodes []ast.Node) {
+	for _, node := range nodes {
+		v.walk(node)
+	}
+}
+
+func (v *validator) checkModifier(node *ast.PipeNode) {
+	name := node.Function.Value
+	pos := node.Function.Token.Pos()
+	if _, ok := v.gsm.modifier(name); !ok {
+		v.errorf(pos, "unknown modifier %s", name)
+		return
+	}
+	if arity, ok := v.gsm.modifiers.Arity(name); ok {
+		if err := arity.Check(len(node.Args)); err != nil {
+			v.errorf(pos, "modifier %s: %s", name, err)
+		}
+	}
+}
+
+func (v *validator) checkFunction(node *ast.CallExpression) {
+	name := node.Function.Value
+	pos := node.Function.Token.Pos()
+	var arity modifier.Arity
+	if fn, ok := v.gsm.function(name); ok {
+		arity = funcArity(fn.Type())
+	} else if a, ok := builtinFunctionArity[name]; ok {
+		arity = a
+	} else {
+		v.errorf(pos, "function %s is not defined", name)
+		return
+	}
+	if err := arity.Check(len(node.Args)); err != nil {
+		v.errorf(pos, "%s: %s", name, err)
+	}
+}